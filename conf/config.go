@@ -1,12 +1,14 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/samitpal/goProbe/modules"
 	"github.com/samitpal/goProbe/modules/http"
 	"github.com/samitpal/goProbe/modules/ping_port"
+	"reflect"
 )
 
 /* Example json config
@@ -28,6 +30,19 @@ import (
 	        }
     }
 ]
+
+The same probes along with a module template for the /probe handler, using the object form of the config.
+{
+    "probes": [ ... ],
+    "modules": {
+        "http_200": {
+            "probe_type": "http",
+            "probe_config": {
+                "probe_timeout": 10
+            }
+        }
+    }
+}
 */
 
 type Probes struct {
@@ -35,51 +50,161 @@ type Probes struct {
 	ProbeConfig json.RawMessage `json:"probe_config"` // Here we branch to the respective probe type config.
 }
 
+// Config is the complete goProbe config. The config file can either be a json list of probes, as in the example
+// above, or a json object with the list of probes under the "probes" key along with the other config sections.
+type Config struct {
+	Probes []Probes `json:"probes"`
+
+	// Modules are named probe configs without a target. They are run against the target given at run time
+	// via the /probe handler.
+	Modules map[string]Probes `json:"modules"`
+
+	// Push lists the metric push providers. It is handled by the push_metric package.
+	Push json.RawMessage `json:"push"`
+
+	// Notify sets up the notifications of the probe state changes. It is handled by the notify package.
+	Notify json.RawMessage `json:"notify"`
+
+	// Maintenance lists the recurring maintenance windows of the probes. It is handled by the maintenance package.
+	Maintenance json.RawMessage `json:"maintenance"`
+}
+
+// ParseConfig parses the given json config. It accepts both the list and the object form of the config.
+func ParseConfig(config []byte) (*Config, error) {
+	c := new(Config)
+	if trimmed := bytes.TrimSpace(config); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(config, &c.Probes)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	err := json.Unmarshal(config, c)
+	if err != nil {
+		return nil, err
+	}
+	for name, m := range c.Modules {
+		t, err := newProbeModule(m.ProbeType)
+		if err != nil {
+			return nil, fmt.Errorf("Module '%s': %v", name, err)
+		}
+		if _, ok := t.(modules.Targeter); !ok {
+			return nil, fmt.Errorf("Module '%s': probe type '%s' can not be run against a given target", name, m.ProbeType)
+		}
+	}
+	return c, nil
+}
+
+// ProbeTypes lists the probe types known by newProbeModule.
+var ProbeTypes = []string{"http", "ping_port"}
+
+// newProbeModule returns a new, unconfigured probe module of the given type.
+func newProbeModule(probeType string) (modules.Prober, error) {
+	switch probeType {
+	case "http":
+		return http.NewHttpProbe(), nil
+	case "ping_port":
+		return ping_port.NewPingPortProbe(), nil
+		// Add a new case statement here for a new probe type, and add the type to ProbeTypes.
+	}
+	return nil, fmt.Errorf("Unknown probe type '%s'", probeType)
+}
+
+// ProbeType returns the probe type of the given probe module, e.g http, or an empty string if unknown.
+func ProbeType(p modules.Prober) string {
+	for _, pt := range ProbeTypes {
+		t, err := newProbeModule(pt)
+		if err == nil && reflect.TypeOf(t) == reflect.TypeOf(p) {
+			return pt
+		}
+	}
+	return ""
+}
+
 func SetupConfig(config []byte) ([]modules.Prober, error) {
-	var p []Probes
-	err := json.Unmarshal(config, &p)
+	c, err := ParseConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	return SetupProbes(c.Probes)
+}
 
+// SetupProbes sets up the probe modules of the given probe configs. Probes whose config fail the module's
+// Prepare method are logged and left out.
+func SetupProbes(p []Probes) ([]modules.Prober, error) {
 	var probes []modules.Prober
-	// TODO: It is a bit ugly here. A new probe module will need its own 'if/else if' block. Make it better.
 	for _, c := range p {
-		if c.ProbeType == "http" {
-			t := http.NewHttpProbe()
-			err := json.Unmarshal(c.ProbeConfig, t)
-			if err != nil {
-				return nil, err
-			}
-			// Call the module's Prepare method which should do its own initialization (if any).
-			err = t.Prepare()
-			if err == nil {
-				probes = append(probes, t)
-			} else {
-				glog.Errorf("Error in config: %v", err)
-			}
-		} else if c.ProbeType == "ping_port" {
-			t := ping_port.NewPingPortProbe()
-			err := json.Unmarshal(c.ProbeConfig, t)
-			if err != nil {
-				return nil, err
-			}
-			// Call the module's Prepare method which should do its own initialization (if any).
-			err = t.Prepare()
-			if err == nil {
-				probes = append(probes, t)
-			} else {
-				glog.Errorf("Error in config: %v", err)
-			}
+		t, err := newProbeModule(c.ProbeType)
+		if err != nil {
+			glog.Errorf("Error in config: %v", err)
+			continue
+		}
+		err = json.Unmarshal(c.ProbeConfig, t)
+		if err != nil {
+			return nil, err
+		}
+		// Call the module's Prepare method which should do its own initialization (if any).
+		err = t.Prepare()
+		if err == nil {
+			probes = append(probes, t)
+		} else {
+			glog.Errorf("Error in config: %v", err)
 		}
-		// Add a new 'else if' statement here for a new probe type.
 	}
-	if err = checkDuplicateProbeNames(probes); err != nil {
+	if err := checkDuplicateProbeNames(probes); err != nil {
 		return nil, err
 	}
 	return probes, nil
 }
 
+// SetupProbe sets up the probe module of the given probe config. Unlike SetupProbes, it returns the errors of the
+// probe type and of the module's Prepare method.
+func SetupProbe(c Probes) (modules.Prober, error) {
+	t, err := newProbeModule(c.ProbeType)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(c.ProbeConfig, t); err != nil {
+		return nil, err
+	}
+	// Call the module's Prepare method which should do its own initialization (if any).
+	if err = t.Prepare(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetupModuleProbe sets up a probe from the given module template to be run against the given target. The probe
+// is named after the module unless the template sets a probe name itself.
+func SetupModuleProbe(name string, m Probes, target string) (modules.Prober, error) {
+	t, err := newProbeModule(m.ProbeType)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.ProbeConfig) > 0 {
+		if err = json.Unmarshal(m.ProbeConfig, t); err != nil {
+			return nil, err
+		}
+	}
+	if t.Name() == nil {
+		pn, _ := json.Marshal(map[string]string{"probe_name": name})
+		if err = json.Unmarshal(pn, t); err != nil {
+			return nil, err
+		}
+	}
+	tg, ok := t.(modules.Targeter)
+	if !ok {
+		return nil, fmt.Errorf("Probe type '%s' can not be run against a given target", m.ProbeType)
+	}
+	if err = tg.SetTarget(target); err != nil {
+		return nil, err
+	}
+	if err = t.Prepare(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
 // checkDuplicateProbeNames checks for duplicate probe names.
 func checkDuplicateProbeNames(pms []modules.Prober) error {
 	probeCount := make(map[string]int)
@@ -3,6 +3,7 @@ package conf
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSetupConfig(t *testing.T) {
@@ -136,26 +137,18 @@ func TestSetupConfig(t *testing.T) {
 	}
 
 	//Test 3
-	t1 := 20
-	t2 := 40
-	t3 := 40
-	t5 := 10
-	want3 := []*int{&t1, &t2, &t3, &t5}
+	want3 := []time.Duration{20 * time.Second, 40 * time.Second, 40 * time.Second, 10 * time.Second}
 	for i, _ := range got {
-		if *want3[i] != *got[i].TimeoutSecs() {
-			t.Errorf("Got: %v\n Want: %v", *got[i].TimeoutSecs(), *want3[i])
+		if want3[i] != got[i].Timeout().Duration {
+			t.Errorf("Got: %v\n Want: %v", got[i].Timeout(), want3[i])
 		}
 	}
 
 	// Test 4
-	i1 := 30
-	i2 := 60
-	i3 := 60
-	i5 := 60
-	want4 := []*int{&i1, &i2, &i3, &i5}
+	want4 := []time.Duration{30 * time.Second, time.Minute, time.Minute, time.Minute}
 	for i, _ := range got {
-		if *want4[i] != *got[i].RunIntervalSecs() {
-			t.Errorf("Got: %v\n Want: %v", *got[i].RunIntervalSecs(), *want4[i])
+		if want4[i] != got[i].RunInterval().Duration {
+			t.Errorf("Got: %v\n Want: %v", got[i].RunInterval(), want4[i])
 		}
 	}
 }
@@ -235,3 +228,148 @@ func TestGetProbeNames(t *testing.T) {
 		t.Errorf("Got: %v\n Want: %v", probeNames, []string{"probe1", "probe2"})
 	}
 }
+
+func TestParseConfig(t *testing.T) {
+	config := []byte(`
+        {
+        "probes": [
+            {
+            "probe_type": "http",
+            "probe_config": {
+                "probe_name": "probe1",
+                "probe_url": "http://example.com"
+            }
+            }
+        ],
+        "modules": {
+            "http_200": {
+                "probe_type": "http",
+                "probe_config": {
+                    "probe_timeout": 10
+                }
+            }
+        }
+        }`)
+	c, err := ParseConfig(config)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(c.Probes) != 1 {
+		t.Errorf("Got %d probes, Want: 1", len(c.Probes))
+	}
+	if _, ok := c.Modules["http_200"]; !ok {
+		t.Errorf("Module http_200 is missing, got: %v", c.Modules)
+	}
+
+	// the object form of the config should set up the same probes as the list form.
+	probes, err := SetupConfig(config)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(GetProbeNames(probes), []string{"probe1"}) {
+		t.Errorf("Got: %v\n Want: %v", GetProbeNames(probes), []string{"probe1"})
+	}
+
+	// unknown module types should be rejected.
+	_, err = ParseConfig([]byte(`{"modules": {"invalid": {"probe_type": "invalid"}}}`))
+	if err == nil {
+		t.Error("Expecting error due to unknown module type, but test is passing")
+	}
+}
+
+func TestSetupProbe(t *testing.T) {
+	p, err := SetupProbe(Probes{ProbeType: "http", ProbeConfig: []byte(`{"probe_name": "web", "probe_url": "http://example.com"}`)})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if *p.Name() != "web" || ProbeType(p) != "http" {
+		t.Errorf("Got: %s, %s", *p.Name(), ProbeType(p))
+	}
+
+	for _, c := range []Probes{
+		{ProbeType: "invalid", ProbeConfig: []byte(`{"probe_name": "web"}`)},
+		{ProbeType: "http", ProbeConfig: []byte(`{"probe_name": "web"}`)},
+		{ProbeType: "http", ProbeConfig: []byte(`{"probe_name": 1}`)},
+	} {
+		if _, err = SetupProbe(c); err == nil {
+			t.Errorf("%s: Expecting error, but test is passing", c.ProbeConfig)
+		}
+	}
+}
+
+func TestSetupModuleProbe(t *testing.T) {
+	m := Probes{ProbeType: "ping_port", ProbeConfig: []byte(`{"probe_timeout": 5}`)}
+	p, err := SetupModuleProbe("tcp_connect", m, "example.com:22")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want := `{
+ "probe_name": "tcp_connect",
+ "probe_interval": 60,
+ "probe_timeout": 5,
+ "probe_host_name": "example.com",
+ "probe_host_port": 22,
+ "probe_network": "tcp"
+}`
+	if p.RetConfig() != want {
+		t.Errorf("Got: \n%v\n Want: \n%v", p.RetConfig(), want)
+	}
+
+	m = Probes{ProbeType: "http", ProbeConfig: []byte(`{"probe_name": "web"}`)}
+	p, err = SetupModuleProbe("http_200", m, "example.com/health")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if *p.Name() != "web" {
+		t.Errorf("Got: %v\n Want: web", *p.Name())
+	}
+
+	// ping_port targets need a port unless the module sets one.
+	m = Probes{ProbeType: "ping_port"}
+	if _, err = SetupModuleProbe("tcp_connect", m, "example.com"); err == nil {
+		t.Error("Expecting error due to missing port, but test is passing")
+	}
+}
+
+func TestProbeType(t *testing.T) {
+	for _, pt := range ProbeTypes {
+		p, err := newProbeModule(pt)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if got := ProbeType(p); got != pt {
+			t.Errorf("Got: %s\n Want: %s", got, pt)
+		}
+	}
+}
+
+func TestSetupConfigIntervals(t *testing.T) {
+	got, err := SetupConfig([]byte(`[
+		{"probe_type": "http", "probe_config": {"probe_name": "web", "probe_url": "http://example.com", "probe_interval": "5 * * * *", "probe_timeout": "1m30s"}},
+		{"probe_type": "ping_port", "probe_config": {"probe_name": "ssh", "probe_host_name": "example.com", "probe_host_port": 22, "probe_interval": "500ms", "probe_timeout": "250ms"}}
+	]`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got[0].RunInterval().Cron == nil || got[0].Timeout().Duration != 90*time.Second {
+		t.Errorf("Got: %v, %v\n Want: 5 * * * *, 1m30s", got[0].RunInterval(), got[0].Timeout())
+	}
+	if got[1].RunInterval().Duration != 500*time.Millisecond || got[1].Timeout().Duration != 250*time.Millisecond {
+		t.Errorf("Got: %v, %v\n Want: 500ms, 250ms", got[1].RunInterval(), got[1].Timeout())
+	}
+	want := `{
+ "probe_name": "ssh",
+ "probe_interval": "500ms",
+ "probe_timeout": "250ms",
+ "probe_host_name": "example.com",
+ "probe_host_port": 22,
+ "probe_network": "tcp"
+}`
+	if got[1].RetConfig() != want {
+		t.Errorf("Got: \n%v\n Want: \n%v", got[1].RetConfig(), want)
+	}
+
+	if _, err = SetupConfig([]byte(`[{"probe_type": "http", "probe_config": {"probe_name": "web", "probe_url": "http://example.com", "probe_interval": "every hour"}}]`)); err == nil {
+		t.Error("Expecting error due to the invalid interval, but test is passing")
+	}
+}
@@ -2,10 +2,6 @@ package main
 
 import (
 	"github.com/hashicorp/consul/api"
-	"github.com/samitpal/goProbe/metric_export"
-	"github.com/samitpal/goProbe/misc"
-	"github.com/samitpal/goProbe/modules"
-	"github.com/samitpal/goProbe/push_metric"
 	"os"
 )
 
@@ -28,17 +24,14 @@ func getConsulClient() (*api.Client, error) {
 }
 
 type DoJob struct {
-	psr    push_metric.Pusher
-	probes []modules.Prober
-	mExp   metric_export.MetricExporter
-	ps     *misc.ProbesStatus
+	sched *scheduler
 }
 
-func NewDoJob(psr push_metric.Pusher, probes []modules.Prober, mExp metric_export.MetricExporter, ps *misc.ProbesStatus) *DoJob {
-	return &DoJob{psr, probes, mExp, ps}
+func NewDoJob(sched *scheduler) *DoJob {
+	return &DoJob{sched}
 }
 
 func (j DoJob) DoJobFunc(stopCh chan bool, doneCh chan bool) {
 	// we do not use doneCh since this is a continuously method.
-	runProbes(j.psr, j.probes, j.mExp, j.ps, stopCh)
+	j.sched.run(stopCh)
 }
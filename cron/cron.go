@@ -0,0 +1,176 @@
+// Package cron parses the standard 5 field cron expressions, e.g "5 * * * *" for minute 5 of every hour, and computes
+// the times they match.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. The fields are, in order, the minute (0-59), the hour (0-23), the day of
+// the month (1-31), the month (1-12 or jan-dec) and the day of the week (0-7 or sun-sat, 0 and 7 being sunday). Each
+// field is either *, a value, a range like 1-5, or a list of them like 1,15. A step can be added to * and to the
+// ranges, e.g */15. As usual, a time matches if it matches either of the day fields when both are set.
+type Schedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64 // bit sets of the matching values.
+	domStar, dowStar              bool   // whether the day fields are *.
+}
+
+// macros are the expressions of the @ shorthands.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// maxYears is how far Next looks for a match, so that it returns for the expressions which never match, e.g Feb 30.
+const maxYears = 5
+
+// Parse parses the given cron expression, or one of the @yearly, @monthly, @weekly, @daily and @hourly shorthands.
+func Parse(expr string) (*Schedule, error) {
+	e := strings.TrimSpace(expr)
+	if m, ok := macros[strings.ToLower(e)]; ok {
+		e = m
+	}
+	f := strings.Fields(e)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression '%s', expecting 5 fields", expr)
+	}
+	s := &Schedule{expr: expr, domStar: f[2] == "*", dowStar: f[4] == "*"}
+	var err error
+	if s.minute, err = parseField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid minute in cron expression '%s': %v", expr, err)
+	}
+	if s.hour, err = parseField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Invalid hour in cron expression '%s': %v", expr, err)
+	}
+	if s.dom, err = parseField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Invalid day of month in cron expression '%s': %v", expr, err)
+	}
+	if s.month, err = parseField(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("Invalid month in cron expression '%s': %v", expr, err)
+	}
+	if s.dow, err = parseField(f[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("Invalid day of week in cron expression '%s': %v", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is sunday too.
+	}
+	return s, nil
+}
+
+// parseField returns the bit set of the values of the given field, whose values are within min and max.
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		r, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			r = part[:i]
+		}
+		var lo, hi int
+		var err error
+		switch {
+		case r == "*":
+			lo, hi = min, max
+		case strings.Contains(r, "-"):
+			bounds := strings.SplitN(r, "-", 2)
+			if lo, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			if lo, err = parseValue(r, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				hi = max // e.g 5/15 is 5-max/15.
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("'%s' is out of the %d-%d range", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	return v, nil
+}
+
+// String returns the cron expression.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Matches tells whether the minute of the given time matches the schedule, in the location of the time.
+func (s *Schedule) Matches(t time.Time) bool {
+	return has(s.month, int(t.Month())) && s.dayMatches(t) && has(s.hour, t.Hour()) && has(s.minute, t.Minute())
+}
+
+// Next returns the first time matching the schedule strictly after the given time, in the location of the given
+// time. It returns the zero time if there is none within the next few years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + maxYears
+	for t.Year() <= limit {
+		prev := t
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !t.After(prev) {
+			t = prev.Add(time.Minute) // time.Date went back, e.g within a daylight saving time change.
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
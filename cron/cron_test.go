@@ -0,0 +1,74 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "5 * * * *", "*/15 9-17 * * mon-fri", "0 0 1,15 * *", "30 2 * jan,JUL sun", "0 0 * * 7", "5/20 * * * *", "@daily", "@Hourly"} {
+		if _, err := Parse(expr); err != nil {
+			t.Errorf("%s: Error: %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%s: Expecting error, but test is passing", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	from := time.Date(2016, 1, 29, 10, 7, 30, 0, time.UTC) // a friday.
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2016, 1, 29, 10, 8, 0, 0, time.UTC)},
+		{"5 * * * *", from, time.Date(2016, 1, 29, 11, 5, 0, 0, time.UTC)},
+		{"*/15 9-17 * * mon-fri", from, time.Date(2016, 1, 29, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", from, time.Date(2016, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", from, time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", from, time.Date(2016, 1, 29, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7)}, // the 13th or a friday.
+		{"@yearly", from, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", from, time.Time{}},
+		// exactly on a match, the next one is returned.
+		{"5 * * * *", time.Date(2016, 1, 29, 11, 5, 0, 0, time.UTC), time.Date(2016, 1, 29, 12, 5, 0, 0, time.UTC)},
+		// in the location of the given time, across the daylight saving time change of 2016-03-27.
+		{"30 2 * * *", time.Date(2016, 3, 26, 12, 0, 0, 0, paris), time.Date(2016, 3, 28, 2, 30, 0, 0, paris)},
+		{"0 3 * * *", time.Date(2016, 3, 27, 1, 0, 0, 0, paris), time.Date(2016, 3, 27, 3, 0, 0, 0, paris)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: Error: %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s from %v: Got: %v\n Want: %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	s, err := Parse("*/15 9-17 * * mon-fri")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for tm, want := range map[time.Time]bool{
+		time.Date(2016, 1, 29, 9, 45, 59, 0, time.UTC): true,
+		time.Date(2016, 1, 29, 9, 46, 0, 0, time.UTC):  false,
+		time.Date(2016, 1, 29, 18, 0, 0, 0, time.UTC):  false,
+		time.Date(2016, 1, 30, 10, 0, 0, 0, time.UTC):  false, // a saturday.
+		time.Date(2016, 2, 1, 17, 15, 0, 0, time.UTC):  true,
+	} {
+		if got := s.Matches(tm); got != want {
+			t.Errorf("%v: Got: %v\n Want: %v", tm, got, want)
+		}
+	}
+}
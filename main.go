@@ -2,135 +2,511 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/gorilla/handlers"
 	leader_election "github.com/samitpal/consul-client-master-election/election_api"
 	"github.com/samitpal/goProbe/conf"
 	"github.com/samitpal/goProbe/log"
+	"github.com/samitpal/goProbe/maintenance"
 	"github.com/samitpal/goProbe/metric_export"
 	"github.com/samitpal/goProbe/misc"
 	"github.com/samitpal/goProbe/modules"
+	"github.com/samitpal/goProbe/notify"
 	"github.com/samitpal/goProbe/push_metric"
+	"github.com/samitpal/goProbe/schedule"
+	"github.com/samitpal/goProbe/store"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var (
-	listenAddress     = flag.String("listen-address", ":8080", "Address to listen on for web interface.")
-	configFlag        = flag.String("config", "./probe_config.json", "Path to the probe json config.")
-	probeSpaceOutTime = flag.Int("probe_space_out_time", 15, "Max sleep time between probes to allow spacing out of the probes at startup.")
-	expositionType    = flag.String("exposition_type", "json", "Metric exposition format.")
-	dryRun            = flag.Bool("dry_run", false, "Dry run mode where it does everything except running the probes.")
-	metricsPath       = flag.String("metric_path", "/metrics", "Metric exposition path.")
-	webLogDir         = flag.String("weblog_dir", "", "Directory path of the web log.")
-	haMode            = flag.Bool("ha_mode", false, "Whether to use consul for High Availabity mode.")
-	pushMetric        = flag.Bool("push_metric", false, "Whether to push metric to a given provier. If set, one needs to set the GOPROBE_PUSH_TO env variable")
+	listenAddress      = flag.String("listen-address", ":8080", "Address to listen on for web interface.")
+	configFlag         = flag.String("config", "./probe_config.json", "Path to the probe json config.")
+	probeSpaceOutTime  = flag.Int("probe_space_out_time", 15, "Max delay in seconds of the first run of a probe, to spread the probes at startup. The delay of each probe is derived from its name.")
+	maxConcurrent      = flag.Int("max_concurrent_probes", 0, "Max number of probe runs at once. No limit if 0.")
+	maxPerTarget       = flag.Int("max_probes_per_target", 0, "Max number of probe runs at once against the same target host. No limit if 0.")
+	expositionType     = flag.String("exposition_type", "json", "Metric exposition format.")
+	dryRun             = flag.Bool("dry_run", false, "Dry run mode where it does everything except running the probes.")
+	metricsPath        = flag.String("metric_path", "/metrics", "Metric exposition path.")
+	webLogDir          = flag.String("weblog_dir", "", "Directory path of the web log.")
+	haMode             = flag.Bool("ha_mode", false, "Whether to use consul for High Availabity mode.")
+	failuresBeforeDown = flag.Int("failures_before_down", 1, "Default number of consecutive failed runs for a probe to be down. Can be set per probe.")
+	successesBeforeUp  = flag.Int("successes_before_up", 1, "Default number of consecutive successful runs for a down probe to be up again. Can be set per probe.")
+	flapWindow         = flag.Duration("flap_window", 30*time.Minute, "Default window within which the state changes of a probe are counted for flap detection. Can be set per probe.")
+	flapThreshold      = flag.Int("flap_threshold", 6, "Default number of state changes within the flap window for a probe to be flapping. 0 disables flap detection. Can be set per probe.")
+	eventBuffer        = flag.Int("event_buffer", misc.DefaultEventBuffer, "Number of probe results buffered for each client of the /api/v1/events stream. The results are dropped for a client whose buffer is full.")
+	historySize        = flag.Int("history_size", misc.DefaultHistorySize, "Number of runs kept in the history of each probe.")
+	templateDir        = flag.String("template_dir", "", "Optional directory of html templates overriding the embedded ones, e.g to customise the web pages.")
+	stateDir           = flag.String("state_dir", "", "Directory of the file where the probe metrics, status and history are saved to survive restarts. Not saved if empty.")
+	stateSaveInterval  = flag.Duration("state_save_interval", time.Minute, "How often the probe state is saved. Valid with state_dir.")
+	enableTestAPI      = flag.Bool("enable_test_api", false, "Whether to serve POST /api/v1/test, which runs any probe config it is sent, e.g against internal addresses. Only enable it where the api is not reachable by untrusted clients.")
+	pushMetric         = flag.Bool("push_metric", false, "Whether to push metric to a given provier. If set, one needs to set the GOPROBE_PUSH_TO env variable, unless the push providers are listed in the push section of the config.")
 )
 
-func checkFlags() {
-	if *pushMetric && (*expositionType == "prometheus") {
-		glog.Exitln("Exposition type: prometheus is not compatible with push_metric flag")
-	}
+// probeRun holds the outcome of a single run of a probe.
+type probeRun struct {
+	data      *modules.ProbeData // the probe response. Only set for the success and failure results.
+	result    string             // one of the metric_export.Result* values.
+	startTime int64              // Unix epoch in nano seconds.
+	endTime   int64              // Unix epoch in nano seconds.
+	err       error              // the probe error, for the error and timeout results.
+	attempts  int                // the number of attempts, more than 1 if the run was retried.
 }
 
-// runProbes actually runs the probes. This is the core.
-func runProbes(pusher push_metric.Pusher, probes []modules.Prober, mExp metric_export.MetricExporter, ps *misc.ProbesStatus, stopCh chan bool) {
-	for _, p := range probes {
-		// Add some randomness to space out the probes a bit at start up.
-		r := rand.New(rand.NewSource(time.Now().UnixNano()))
-		time.Sleep(time.Duration(r.Intn(*probeSpaceOutTime)) * time.Second)
-		if *pushMetric {
-			pusher.Setup()
-		}
-		go func(p modules.Prober) {
-			for {
-				// Buffered channel so that the read happens even if there is nothing to receive it. Needed to
-				// handle the timeout scenario as well as the situaion when the go routine has to return on stop
-				// signal.
-				respCh := make(chan *modules.ProbeData, 1)
-				errCh := make(chan error, 1)
-
-				pn := *p.Name()
-				to := *p.TimeoutSecs()
-				timer := time.NewTimer(time.Duration(*p.RunIntervalSecs()) * time.Second)
-
-				glog.Infof("Launching new probe:%s", pn)
-				startTime := time.Now().UnixNano()
-				startTimeSecs := startTime / 1000000000 // used to expose time field in json metric expostion.
-				go p.Run(respCh, errCh)
-
-				select {
-				case msg := <-respCh:
-					err := misc.CheckProbeData(msg)
-					mExp.IncProbeCount(pn, startTimeSecs)
-					if err != nil {
-						glog.Errorf("Error: %v", err)
-						mExp.IncProbeErrorCount(pn, startTimeSecs)
-						mExp.SetFieldValuesUnexpected(pn, startTimeSecs)
-						ps.WriteProbeErrorStatus(pn, startTime, time.Now().UnixNano())
-					} else {
-						mExp.SetFieldValues(pn, msg, startTimeSecs)
-						ps.WriteProbeStatus(pn, msg, startTime, time.Now().UnixNano())
-					}
-				case err_msg := <-errCh:
-					glog.Errorf("Probe %s error'ed out: %v", pn, err_msg)
-					mExp.IncProbeCount(pn, startTimeSecs)
-					mExp.IncProbeErrorCount(pn, startTimeSecs)
-					mExp.SetFieldValuesUnexpected(pn, startTimeSecs)
-					ps.WriteProbeErrorStatus(pn, startTime, time.Now().UnixNano())
-				case <-time.After(time.Duration(to) * time.Second):
-					glog.Errorf("Timed out probe:%v ", pn)
-					mExp.IncProbeCount(pn, startTimeSecs)
-					mExp.IncProbeTimeoutCount(pn, startTimeSecs)
-					mExp.SetFieldValuesUnexpected(pn, startTimeSecs)
-					ps.WriteProbeTimeoutStatus(pn, startTime, time.Now().UnixNano())
-				case <-stopCh:
-					glog.Infof("Goroutine probe named: %s recieved stop signal. Returning.", pn)
-					return
-				}
-				if *pushMetric {
-					go pusher.PushMetric(mExp, pn)
-				}
-				<-timer.C
+// runProbeOnce runs the given probe and waits till it responds, errors out or times out. It returns nil if
+// a stop signal is received in the meantime.
+func runProbeOnce(p modules.Prober, stopCh chan bool) *probeRun {
+	// Buffered channel so that the read happens even if there is nothing to receive it. Needed to
+	// handle the timeout scenario as well as the situaion when the go routine has to return on stop
+	// signal.
+	respCh := make(chan *modules.ProbeData, 1)
+	errCh := make(chan error, 1)
+
+	pn := *p.Name()
+	to := p.Timeout().Duration
+
+	glog.Infof("Launching new probe:%s", pn)
+	run := &probeRun{startTime: time.Now().UnixNano()}
+	go p.Run(respCh, errCh)
+
+	select {
+	case msg := <-respCh:
+		err := misc.CheckProbeData(msg)
+		if err != nil {
+			glog.Errorf("Error: %v", err)
+			run.result = metric_export.ResultError
+			run.err = err
+		} else {
+			run.data = msg
+			if *msg.IsUp == 1 {
+				run.result = metric_export.ResultSuccess
+			} else {
+				run.result = metric_export.ResultFailure
 			}
-		}(p)
+		}
+	case err_msg := <-errCh:
+		glog.Errorf("Probe %s error'ed out: %v", pn, err_msg)
+		run.result = metric_export.ResultError
+		run.err = err_msg
+	case <-time.After(to):
+		glog.Errorf("Timed out probe:%v ", pn)
+		run.result = metric_export.ResultTimeout
+		run.err = fmt.Errorf("Timed out after %v", to)
+	case <-stopCh:
+		return nil
 	}
+	run.endTime = time.Now().UnixNano()
+	return run
 }
 
-func main() {
+// runProbeAttempts runs the given probe till an attempt succeeds, up to 1 + its retries times, waiting for its retry
+// delay between the attempts. The returned run is the last attempt, with the start time of the first one. It returns
+// nil if a stop signal is received in the meantime.
+func runProbeAttempts(p modules.Prober, stopCh chan bool) *probeRun {
+	o := p.Options()
+	var delay time.Duration
+	if o.RetryDelay != nil {
+		delay = o.RetryDelay.Duration
+	}
+	var startTime int64
+	for attempt := 1; ; attempt++ {
+		run := runProbeOnce(p, stopCh)
+		if run == nil {
+			return nil
+		}
+		if attempt == 1 {
+			startTime = run.startTime
+		}
+		if run.result == metric_export.ResultSuccess || attempt > o.Retries {
+			run.startTime = startTime
+			run.attempts = attempt
+			return run
+		}
+		glog.Infof("Probe %s attempt %d of %d: %s, retrying in %v.", *p.Name(), attempt, o.Retries+1, run.result, delay)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return nil
+		}
+	}
+}
 
-	flag.Parse()
-	checkFlags()
-	var pusher push_metric.Pusher
-	var err error
-	if *pushMetric {
-		pusher, err = push_metric.SetupProviders()
-		if err != nil {
-			glog.Exitf("Problem while setting up push provider: %v", err)
+// recordProbeRun updates the metrics and, if ps is not nil, the probe status with the outcome of a probe run.
+func recordProbeRun(pn string, run *probeRun, mExp metric_export.MetricExporter, ps *misc.ProbesStatus) {
+	startTimeSecs := run.startTime / 1000000000 // used to expose time field in json metric expostion.
+	mExp.IncProbeCount(pn, startTimeSecs)
+	mExp.IncProbeResultCount(pn, run.result, startTimeSecs)
+	mExp.SetProbeAttempts(pn, run.attempts, startTimeSecs)
+	switch run.result {
+	case metric_export.ResultSuccess, metric_export.ResultFailure:
+		mExp.SetFieldValues(pn, run.data, startTimeSecs)
+		if ps != nil {
+			ps.WriteProbeStatus(pn, run.data, run.startTime, run.endTime)
+		}
+	case metric_export.ResultError:
+		mExp.IncProbeErrorCount(pn, startTimeSecs)
+		mExp.SetFieldValuesUnexpected(pn, startTimeSecs)
+		if ps != nil {
+			ps.WriteProbeErrorStatus(pn, run.startTime, run.endTime)
+		}
+	case metric_export.ResultTimeout:
+		mExp.IncProbeTimeoutCount(pn, startTimeSecs)
+		mExp.SetFieldValuesUnexpected(pn, startTimeSecs)
+		if ps != nil {
+			ps.WriteProbeTimeoutStatus(pn, run.startTime, run.endTime)
+		}
+	}
+	if ps != nil {
+		state := ps.ReadProbeStatus(pn).State
+		mExp.SetProbeState(pn, state, startTimeSecs)
+		ps.AddHistory(pn, newHistoryEntry(run, state))
+	}
+}
+
+// newHistoryEntry returns the probe history entry of a probe run.
+func newHistoryEntry(run *probeRun, state string) misc.HistoryEntry {
+	e := misc.HistoryEntry{StartTime: run.startTime, EndTime: run.endTime, Result: run.result, State: state}
+	if run.err != nil {
+		e.Error = run.err.Error()
+	}
+	if run.attempts > 1 {
+		e.Attempts = run.attempts
+	}
+	if run.data != nil {
+		e.Latency = run.data.Latency
+		if run.data.Http != nil && run.data.Http.Status != nil {
+			// The status is like "200 OK".
+			if f := strings.Fields(*run.data.Http.Status); len(f) > 0 {
+				e.StatusCode, _ = strconv.Atoi(f[0])
+			}
 		}
 	}
-	config, err := ioutil.ReadFile(*configFlag)
+	return e
+}
+
+// probeControl is the runtime control of a probe, see misc.ProbeControl.
+type probeControl struct {
+	paused        bool
+	silencedUntil time.Time
+}
+
+// scheduler runs the probes, on schedule as well as on demand, and controls them at runtime.
+type scheduler struct {
+	pipelines push_metric.Pipelines
+	notifier  *notify.Notifier
+	events    *misc.EventBroker
+	probes    []modules.Prober
+	mExp      metric_export.MetricExporter
+	ps        *misc.ProbesStatus
+	maint     *maintenance.Maintenance
+	byName    map[string]modules.Prober
+	locks     map[string]*sync.Mutex // held while a probe runs, by probe name, so that the runs of a probe do not overlap.
+	core      *schedule.Scheduler    // times the scheduled runs and limits the runs at once.
+
+	lock     sync.Mutex
+	controls map[string]probeControl // by probe name, so that the saved ones are restored for the probes still in the config after a restart.
+}
+
+func newScheduler(pipelines push_metric.Pipelines, notifier *notify.Notifier, events *misc.EventBroker, probes []modules.Prober, mExp metric_export.MetricExporter, ps *misc.ProbesStatus, maint *maintenance.Maintenance, sc schedule.Config) *scheduler {
+	s := &scheduler{
+		pipelines: pipelines,
+		notifier:  notifier,
+		events:    events,
+		probes:    probes,
+		mExp:      mExp,
+		ps:        ps,
+		maint:     maint,
+		byName:    make(map[string]modules.Prober),
+		locks:     make(map[string]*sync.Mutex),
+		controls:  make(map[string]probeControl),
+		core:      schedule.New(sc),
+	}
+	s.core.RegisterMetrics(mExp)
+	now := time.Now()
+	for _, p := range probes {
+		s.byName[*p.Name()] = p
+		s.locks[*p.Name()] = new(sync.Mutex)
+		s.exportControl(*p.Name(), now)
+	}
+	notifier.SetMuted(s.muted)
+	return s
+}
+
+// run actually runs the probes on schedule till a stop signal is received. This is the core.
+func (s *scheduler) run(stopCh chan bool) {
+	jobs := make([]schedule.Job, 0, len(s.probes))
+	for _, p := range s.probes {
+		p := p
+		pn := *p.Name()
+		jobs = append(jobs, schedule.Job{
+			Name:     pn,
+			Target:   target(p),
+			Interval: p.RunInterval().Duration,
+			Cron:     p.RunInterval().Cron,
+			Skip: func(due time.Time) bool {
+				s.exportControl(pn, due)
+				_, mode := s.maintenance(pn, due)
+				return mode == maintenance.ModeSkip || s.paused(pn)
+			},
+			Run: func() bool { return s.runProbe(p, stopCh) != nil },
+		})
+	}
+	s.core.Run(jobs, stopCh)
+	glog.Info("Scheduler recieved stop signal. Returning.")
+}
+
+// target returns the host the given probe is run against, if known.
+func target(p modules.Prober) string {
+	if t, ok := p.(modules.Targeter); ok {
+		return t.Target()
+	}
+	return ""
+}
+
+// runProbe runs the given probe, once its ongoing run if any is over, and records, pushes, notifies and publishes
+// the outcome. A failed run within a maintenance window is only added to the history and published. It returns nil
+// if a stop signal is received in the meantime.
+func (s *scheduler) runProbe(p modules.Prober, stopCh chan bool) *probeRun {
+	pn := *p.Name()
+	l := s.locks[pn]
+	l.Lock()
+	defer l.Unlock()
+
+	run := runProbeAttempts(p, stopCh)
+	if run == nil {
+		return nil
+	}
+	prev := misc.StateUnknown
+	if status := s.ps.ReadProbeStatus(pn); status != nil {
+		prev = status.State
+	}
+	if window, _ := s.maintenance(pn, time.Unix(0, run.startTime)); window != "" && run.result != metric_export.ResultSuccess {
+		glog.Infof("Probe %s run in maintenance window %s: %s, left out.", pn, window, run.result)
+		e := newHistoryEntry(run, prev)
+		e.Excluded = window
+		s.ps.AddHistory(pn, e)
+		s.events.Publish(&misc.ProbeEvent{ProbeName: pn, Labels: p.Options().ProbeLabels, HistoryEntry: e})
+		return run
+	}
+	recordProbeRun(pn, run, s.mExp, s.ps)
+	s.pipelines.PushProbe(s.mExp, p)
+	status := s.ps.ReadProbeStatus(pn)
+	s.notifier.Observe(pn, p.Options().ProbeLabels, run.result, prev, status)
+	s.events.Publish(&misc.ProbeEvent{
+		ProbeName:    pn,
+		Labels:       p.Options().ProbeLabels,
+		HistoryEntry: newHistoryEntry(run, status.State),
+	})
+	return run
+}
+
+// RunProbe runs the given probe out of schedule, even if paused, within the concurrency limits. It implements
+// misc.Runner.
+func (s *scheduler) RunProbe(p modules.Prober) *misc.RunResult {
+	release := s.core.Acquire(target(p), nil)
+	defer release()
+	res := newRunResult(*p.Name(), s.runProbe(p, nil))
+	res.State = misc.StateUnknown
+	// a probe whose first runs fail within a maintenance window has no status yet.
+	if status := s.ps.ReadProbeStatus(*p.Name()); status != nil {
+		res.State = status.State
+	}
+	return res
+}
+
+// TestProbe runs the given probe once without recording the outcome, within the concurrency limits. It implements
+// misc.Runner.
+func (s *scheduler) TestProbe(p modules.Prober, stopCh chan bool) *misc.RunResult {
+	release := s.core.Acquire(target(p), stopCh)
+	if release == nil {
+		return nil
+	}
+	defer release()
+	run := runProbeAttempts(p, stopCh)
+	if run == nil {
+		return nil
+	}
+	return newRunResult(*p.Name(), run)
+}
+
+// Control returns the runtime control of the given probe. It implements misc.Controller.
+func (s *scheduler) Control(pn string) misc.ProbeControl {
+	s.lock.Lock()
+	c := s.controls[pn]
+	s.lock.Unlock()
+	now := time.Now()
+	mc := misc.ProbeControl{Paused: c.paused}
+	if now.Before(c.silencedUntil) {
+		mc.SilencedUntil = &c.silencedUntil
+	}
+	mc.Maintenance, _ = s.maintenance(pn, now)
+	return mc
+}
+
+// Pause pauses or resumes the given probe. A paused probe is not run on schedule. It implements misc.Controller.
+func (s *scheduler) Pause(pn string, paused bool) {
+	s.updateControl(pn, func(c *probeControl) { c.paused = paused })
+	if paused {
+		glog.Infof("Probe %s is paused.", pn)
+	} else {
+		glog.Infof("Probe %s is resumed.", pn)
+	}
+}
+
+// Silence silences the given probe till the given time, or unsilences it if the time is zero. A silenced probe runs
+// but its state changes are not notified. It implements misc.Controller.
+func (s *scheduler) Silence(pn string, until time.Time) {
+	s.updateControl(pn, func(c *probeControl) { c.silencedUntil = until })
+	if until.IsZero() {
+		glog.Infof("Probe %s is unsilenced.", pn)
+	} else {
+		glog.Infof("Probe %s is silenced until %v.", pn, until)
+	}
+}
+
+// updateControl updates the runtime control of the given probe and exports it.
+func (s *scheduler) updateControl(pn string, update func(*probeControl)) {
+	s.lock.Lock()
+	c := s.controls[pn]
+	update(&c)
+	if c == (probeControl{}) {
+		delete(s.controls, pn)
+	} else {
+		s.controls[pn] = c
+	}
+	s.lock.Unlock()
+	s.exportControl(pn, time.Now())
+}
+
+func (s *scheduler) paused(pn string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.controls[pn].paused
+}
+
+func (s *scheduler) silenced(pn string, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return now.Before(s.controls[pn].silencedUntil)
+}
+
+// maintenance returns the name and the mode of the maintenance window the given probe is in, if any.
+func (s *scheduler) maintenance(pn string, now time.Time) (window, mode string) {
+	var labels map[string]string
+	if p, ok := s.byName[pn]; ok {
+		labels = p.Options().ProbeLabels
+	}
+	return s.maint.Window(pn, labels, now)
+}
+
+// muted tells whether the state changes of the given probe are not to be notified, the probe being silenced, in a
+// maintenance window or depending on a probe which is down or unreachable.
+func (s *scheduler) muted(pn string, now time.Time) bool {
+	window, _ := s.maintenance(pn, now)
+	return window != "" || s.silenced(pn, now) || s.ps.DownParent(pn) != ""
+}
+
+// exportControl sets the paused, silenced and in maintenance metrics of the given probe.
+func (s *scheduler) exportControl(pn string, now time.Time) {
+	window, _ := s.maintenance(pn, now)
+	s.mExp.SetProbeFlag(pn, metric_export.FlagPaused, s.paused(pn), now.Unix())
+	s.mExp.SetProbeFlag(pn, metric_export.FlagSilenced, s.silenced(pn, now), now.Unix())
+	s.mExp.SetProbeFlag(pn, metric_export.FlagInMaintenance, window != "", now.Unix())
+}
+
+// newRunResult returns the api view of a probe run.
+func newRunResult(pn string, run *probeRun) *misc.RunResult {
+	res := &misc.RunResult{
+		ProbeName: pn,
+		Result:    run.result,
+		StartTime: time.Unix(0, run.startTime),
+		EndTime:   time.Unix(0, run.endTime),
+		Data:      run.data,
+		Attempts:  run.attempts,
+	}
+	if run.err != nil {
+		res.Error = run.err.Error()
+	}
+	return res
+}
+
+// saveOnSignal saves the probe state and exits on SIGINT or SIGTERM.
+func saveOnSignal(st *store.Store) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	glog.Infof("Received %v, saving the probe state and exiting.", sig)
+	if err := st.Stop(); err != nil {
+		glog.Errorf("Failed to save the probe state: %v", err)
+	}
+	glog.Flush()
+	os.Exit(0)
+}
+
+// loadConfig reads, parses and checks the probe config file at the given path, and sets up its probes.
+func loadConfig(path string) (*conf.Config, []modules.Prober, error) {
+	config, err := ioutil.ReadFile(path)
 	if err != nil {
-		glog.Exitf("Error reading probe config file: %v", err)
+		return nil, nil, fmt.Errorf("Error reading probe config file: %v", err)
 	}
-	probes, err := conf.SetupConfig(config)
+	cfg, err := conf.ParseConfig(config)
 	if err != nil {
-		glog.Exitf("Error in probe config setup, exiting: %v", err)
+		return nil, nil, fmt.Errorf("Error parsing probe config: %v", err)
 	}
-	err = misc.CheckProbeConfig(probes)
+	probes, err := conf.SetupProbes(cfg.Probes)
 	if err != nil {
-		glog.Exitf("Error in probe config, exiting: %v", err)
+		return nil, nil, fmt.Errorf("Error in probe config setup: %v", err)
 	}
+	if err = misc.CheckProbeConfig(probes); err != nil {
+		return nil, nil, fmt.Errorf("Error in probe config: %v", err)
+	}
+	return cfg, probes, nil
+}
 
-	probeNames := conf.GetProbeNames(probes)
+func main() {
+
+	flag.Parse()
 	mExp, err := metric_export.SetupMetricExporter(*expositionType)
 	if err != nil {
 		glog.Exitf("Error : %v", err)
 	}
+	cfg, probes, err := loadConfig(*configFlag)
+	mExp.SetConfigReloadStatus(err == nil, time.Now().Unix())
+	if err != nil {
+		glog.Exitf("%v, exiting.", err)
+	}
+	pipelines, err := push_metric.SetupPipelines(cfg.Push)
+	if err != nil {
+		glog.Exitf("Problem while setting up push providers: %v", err)
+	}
+	if *pushMetric && len(pipelines) == 0 {
+		pusher, err := push_metric.SetupProviders()
+		if err != nil {
+			glog.Exitf("Problem while setting up push provider: %v", err)
+		}
+		pipeline, err := push_metric.SetupPipeline(os.Getenv("GOPROBE_PUSH_TO"), pusher)
+		if err != nil {
+			glog.Exitf("Problem while setting up push pipeline: %v", err)
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	notifier, err := notify.SetupNotifier(cfg.Notify)
+	if err != nil {
+		glog.Exitf("Problem while setting up notifications: %v", err)
+	}
+
+	probeNames := conf.GetProbeNames(probes)
+	pipelines.RegisterMetrics(mExp)
 
 	var fh *os.File
 	if *webLogDir != "" {
@@ -143,14 +519,76 @@ func main() {
 	}
 
 	ps := misc.NewProbesStatus(probeNames)
+	if *historySize < 1 {
+		glog.Exitf("Invalid history_size %d, needs to be at least 1", *historySize)
+	}
+	ps.SetHistorySize(*historySize)
+	err = ps.SetStateConfigs(probes, misc.StateConfig{
+		FailuresBeforeDown: *failuresBeforeDown,
+		SuccessesBeforeUp:  *successesBeforeUp,
+		FlapWindow:         *flapWindow,
+		FlapThreshold:      *flapThreshold,
+	})
+	if err != nil {
+		glog.Exitf("Error in probe config, exiting: %v", err)
+	}
+	events := misc.NewEventBroker(*eventBuffer)
+	maint, err := maintenance.Setup(cfg.Maintenance)
+	if err != nil {
+		glog.Exitf("Problem while setting up the maintenance windows: %v", err)
+	}
+	sched := newScheduler(pipelines, notifier, events, probes, mExp, ps, maint, schedule.Config{
+		MaxConcurrent: *maxConcurrent,
+		MaxPerTarget:  *maxPerTarget,
+		MaxJitter:     time.Duration(*probeSpaceOutTime) * time.Second,
+	})
+	notifier.Start()
+	if *stateDir != "" {
+		st, err := store.NewStore(*stateDir, *stateSaveInterval, mExp, ps, sched)
+		if err != nil {
+			glog.Exitf("Problem while setting up the state store: %v", err)
+		}
+		if err = st.Restore(); err != nil {
+			glog.Errorf("%v. Starting with an empty state.", err)
+		}
+		pipelines.Seed(mExp, probes)
+		st.Start()
+		go saveOnSignal(st)
+	}
+	pipelines.Start()
+	tmplPattern := os.Getenv("GOPROBE_TMPL") // still honoured, the templates being embedded now.
+	if *templateDir != "" {
+		tmplPattern = filepath.Join(*templateDir, "*.html")
+	}
+	if tmplPattern != "" {
+		if err = misc.SetupTemplates(tmplPattern); err != nil {
+			glog.Exitf("Problem while setting up the html templates: %v", err)
+		}
+	}
+	api := misc.NewAPI(probes, ps, sched)
+	if *enableTestAPI {
+		api.EnableTest()
+	}
 	http.Handle("/", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(misc.HandleHomePage)))
-	http.Handle("/status", handlers.CombinedLoggingHandler(fh, misc.HandleStatus(ps)))
-	http.Handle("/config", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(misc.HandleConfig(config))))
+	http.Handle("/status", handlers.CombinedLoggingHandler(fh, misc.Negotiate(misc.HandleStatus(ps), http.HandlerFunc(api.HandleStatus))))
+	http.Handle("/config", handlers.CombinedLoggingHandler(fh, misc.Negotiate(misc.HandleConfig(probes), http.HandlerFunc(api.HandleConfig))))
 	http.Handle(*metricsPath, handlers.CombinedLoggingHandler(fh, mExp.MetricHttpHandler()))
+	http.Handle("/api/probes/", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(api.HandleHistory)))
+	http.Handle("/api/v1/probes", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(api.HandleProbes)))
+	http.Handle("/api/v1/probes/", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(api.HandleProbes)))
+	http.Handle("/api/v1/events", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(events.HandleEvents)))
+	http.Handle("/api/v1/config", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(api.HandleConfig)))
+	http.Handle("/api/v1/test", handlers.CombinedLoggingHandler(fh, http.HandlerFunc(api.HandleTest)))
+	http.Handle("/probe", handlers.CombinedLoggingHandler(fh, handleProbe(cfg.Modules)))
 
 	glog.Info("Starting goProbe server.")
 	glog.Infof("Will expose metrics in %s format via %s http path.", *expositionType, *metricsPath)
 	glog.Infof("/config shows current config, /status shows current probe status.")
+	glog.Infof("/api/v1/probes, /api/v1/probes/<name>, /api/v1/probes/<name>/history and /api/v1/config serve the probes, their status and config as json.")
+	glog.Infof("POST /api/v1/probes/<name>/run runs a probe now, POST /api/v1/test runs the posted probe config once if enabled.")
+	glog.Infof("POST /api/v1/probes/<name>/pause, resume, silence and unsilence, sent as json, control a probe at runtime.")
+	glog.Infof("/api/v1/events streams the probe results as Server-Sent Events.")
+	glog.Infof("/probe?module=<name>&target=<addr> runs a module against the target and returns its metrics in prometheus format.")
 
 	if !*dryRun {
 		// Start probing.
@@ -161,10 +599,10 @@ func main() {
 			if err != nil {
 				glog.Fatalf("Fatal error: %v", err)
 			}
-			job := NewDoJob(pusher, probes, mExp, ps)
+			job := NewDoJob(sched)
 			go leader_election.MaybeAcquireLeadership(client, "goProbe/leader", 20, 30, "goProbe", false, job)
 		} else {
-			go runProbes(pusher, probes, mExp, ps, stopCh)
+			go sched.run(stopCh)
 		}
 		if err = http.ListenAndServe(*listenAddress, nil); err != nil {
 			panic(err)
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/modules"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProber answers its runs with the given results in turn, the last one over again. A timeout result does not
+// answer at all.
+type fakeProber struct {
+	modules.ProbeOptions
+	results []string
+
+	mu     sync.Mutex
+	starts []int64 // the start times of the runs.
+	ran    chan bool
+}
+
+func (p *fakeProber) Prepare() error {
+	return nil
+}
+
+func (p *fakeProber) Name() *string {
+	n := "fake"
+	return &n
+}
+
+func (p *fakeProber) Timeout() *modules.Interval {
+	return &modules.Interval{Duration: 20 * time.Millisecond}
+}
+
+func (p *fakeProber) RunInterval() *modules.Interval {
+	return &modules.Interval{Duration: time.Minute}
+}
+
+func (p *fakeProber) RetConfig() string {
+	return ""
+}
+
+func (p *fakeProber) Run(respCh chan<- *modules.ProbeData, errCh chan<- error) {
+	p.mu.Lock()
+	p.starts = append(p.starts, time.Now().UnixNano())
+	res := p.results[len(p.results)-1]
+	if n := len(p.starts); n <= len(p.results) {
+		res = p.results[n-1]
+	}
+	p.mu.Unlock()
+
+	up, latency, now := float64(0), float64(1), time.Now().UnixNano()
+	switch res {
+	case metric_export.ResultSuccess:
+		up = 1
+		fallthrough
+	case metric_export.ResultFailure:
+		respCh <- &modules.ProbeData{IsUp: &up, Latency: &latency, StartTime: &now, EndTime: &now}
+	case metric_export.ResultError:
+		errCh <- errors.New("Connection refused")
+	}
+	if p.ran != nil {
+		p.ran <- true
+	}
+}
+
+// runs returns the start times of the runs so far.
+func (p *fakeProber) runs() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int64(nil), p.starts...)
+}
+
+func TestRunProbeAttempts(t *testing.T) {
+	tests := []struct {
+		results  []string
+		retries  int
+		delay    time.Duration
+		want     string
+		attempts int
+	}{
+		{[]string{"success"}, 0, 0, "success", 1},
+		{[]string{"failure"}, 0, 0, "failure", 1},
+		{[]string{"success"}, 2, 0, "success", 1},
+		{[]string{"failure", "success"}, 2, 10 * time.Millisecond, "success", 2},
+		{[]string{"error", "timeout", "success"}, 2, 0, "success", 3},
+		{[]string{"error", "timeout"}, 1, 10 * time.Millisecond, "timeout", 2},
+		{[]string{"failure"}, 3, 0, "failure", 4},
+	}
+	for _, tt := range tests {
+		p := &fakeProber{results: tt.results}
+		p.Retries = tt.retries
+		if tt.delay > 0 {
+			p.RetryDelay = &modules.Interval{Duration: tt.delay}
+		}
+		before := time.Now().UnixNano()
+		run := runProbeAttempts(p, make(chan bool))
+		if run == nil {
+			t.Fatalf("%v: Got a nil run", tt.results)
+		}
+		starts := p.runs()
+		if run.result != tt.want || run.attempts != tt.attempts || len(starts) != tt.attempts {
+			t.Errorf("%v: Got: %s after %d attempts, %d runs\n Want: %s after %d", tt.results, run.result, run.attempts, len(starts), tt.want, tt.attempts)
+			continue
+		}
+		// the run starts with the first attempt.
+		if run.startTime < before || run.startTime > starts[0] || run.endTime < starts[len(starts)-1] {
+			t.Errorf("%v: Got: %d to %d\n Want from before %d to after %d", tt.results, run.startTime, run.endTime, starts[0], starts[len(starts)-1])
+		}
+		if min := int64(tt.attempts-1) * int64(tt.delay); run.endTime-run.startTime < min {
+			t.Errorf("%v: Got a run of %v\n Want at least %v", tt.results, time.Duration(run.endTime-run.startTime), time.Duration(min))
+		}
+	}
+}
+
+func TestRunProbeAttemptsStop(t *testing.T) {
+	p := &fakeProber{results: []string{"failure"}, ran: make(chan bool, 1)}
+	p.Retries = 1
+	p.RetryDelay = &modules.Interval{Duration: time.Hour}
+	stopCh := make(chan bool)
+	done := make(chan *probeRun)
+	go func() { done <- runProbeAttempts(p, stopCh) }()
+
+	// stopped while waiting for the retry delay, once the first attempt is over.
+	<-p.ran
+	time.Sleep(50 * time.Millisecond)
+	close(stopCh)
+	select {
+	case run := <-done:
+		if run != nil {
+			t.Errorf("Got: %+v\n Want: nil", run)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the run to stop")
+	}
+	if n := len(p.runs()); n != 1 {
+		t.Errorf("Got %d attempts\n Want: 1", n)
+	}
+}
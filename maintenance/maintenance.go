@@ -0,0 +1,238 @@
+// Package maintenance handles the recurring maintenance windows of the maintenance section of the config. During a
+// window the matching probes are either not run, or run without their failures being counted nor notified.
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samitpal/goProbe/cron"
+	"path"
+	"strings"
+	"time"
+)
+
+/* Example json config of the maintenance section.
+"maintenance": [
+    {
+        "name": "db_patching",
+        "cron": "0 2 * * sun",
+        "duration": "2h",
+        "time_zone": "Europe/Paris",
+        "probes": ["db_*"]
+    },
+    {
+        "name": "staging_nights",
+        "days": ["mon", "tue", "wed", "thu", "fri"],
+        "start": "22:00",
+        "end": "06:00",
+        "labels": {"env": "staging"},
+        "mode": "exclude"
+    }
+]
+*/
+
+// The modes of a maintenance window.
+const (
+	ModeSkip    = "skip"    // the probes are not run.
+	ModeExclude = "exclude" // the probes are run, their failures being left out of the metrics, the state and the notifications.
+)
+
+// WindowConfig is a maintenance window. It either starts at the times of a cron expression and lasts for a duration,
+// or spans a daily time range on some days of the week. The range ends the next day if its end is not after its
+// start, e.g 22:00 to 06:00.
+type WindowConfig struct {
+	Name     string            `json:"name"`
+	Cron     string            `json:"cron"`      // the start times, e.g "0 2 * * sun". Goes with duration.
+	Duration string            `json:"duration"`  // e.g 2h.
+	Days     []string          `json:"days"`      // e.g ["sat", "sun"]. Every day if empty. Goes with start and end.
+	Start    string            `json:"start"`     // e.g 22:00.
+	End      string            `json:"end"`       // e.g 06:00.
+	TimeZone string            `json:"time_zone"` // e.g Europe/Paris. UTC if empty.
+	Probes   []string          `json:"probes"`    // probe name globs.
+	Labels   map[string]string `json:"labels"`    // probe labels, all of which the probes need to have.
+	Mode     string            `json:"mode"`      // skip (the default) or exclude.
+}
+
+// window is a set up maintenance window.
+type window struct {
+	c        WindowConfig
+	loc      *time.Location
+	cron     *cron.Schedule
+	duration time.Duration
+	days     [7]bool       // by time.Weekday.
+	start    time.Duration // from midnight.
+	end      time.Duration // from midnight.
+}
+
+// Maintenance tells the maintenance windows the probes are in. The methods of a nil Maintenance do nothing, so that
+// it can be used when no window is configured.
+type Maintenance struct {
+	windows []*window
+}
+
+// Setup sets up the windows of the given maintenance config section. It returns nil if the section is empty.
+func Setup(config json.RawMessage) (*Maintenance, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+	var cs []WindowConfig
+	if err := json.Unmarshal(config, &cs); err != nil {
+		return nil, err
+	}
+	return New(cs)
+}
+
+// New returns the maintenance of the given windows.
+func New(cs []WindowConfig) (*Maintenance, error) {
+	m := new(Maintenance)
+	names := make(map[string]bool)
+	for i, c := range cs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("Maintenance window %d has no name", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("Duplicate maintenance window name '%s'", c.Name)
+		}
+		names[c.Name] = true
+		w, err := newWindow(c)
+		if err != nil {
+			return nil, fmt.Errorf("Maintenance window '%s': %v", c.Name, err)
+		}
+		m.windows = append(m.windows, w)
+	}
+	return m, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func newWindow(c WindowConfig) (*window, error) {
+	if c.Mode == "" {
+		c.Mode = ModeSkip
+	}
+	if c.Mode != ModeSkip && c.Mode != ModeExclude {
+		return nil, fmt.Errorf("Invalid mode '%s', expecting skip or exclude", c.Mode)
+	}
+	if len(c.Probes) == 0 && len(c.Labels) == 0 {
+		return nil, errors.New("Either probes or labels needs to be set")
+	}
+	for _, g := range c.Probes {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("Invalid probe glob '%s': %v", g, err)
+		}
+	}
+	w := &window{c: c, loc: time.UTC}
+	if c.TimeZone != "" {
+		loc, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone '%s': %v", c.TimeZone, err)
+		}
+		w.loc = loc
+	}
+
+	if c.Cron != "" {
+		if len(c.Days) > 0 || c.Start != "" || c.End != "" {
+			return nil, errors.New("Either cron and duration, or days, start and end can be set")
+		}
+		var err error
+		if w.cron, err = cron.Parse(c.Cron); err != nil {
+			return nil, err
+		}
+		if w.duration, err = time.ParseDuration(c.Duration); err != nil || w.duration <= 0 {
+			return nil, fmt.Errorf("Invalid duration '%s', expecting a positive duration, e.g 2h", c.Duration)
+		}
+		return w, nil
+	}
+
+	if c.Start == "" || c.End == "" {
+		return nil, errors.New("Either cron and duration, or start and end need to be set")
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(c.Start); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseTimeOfDay(c.End); err != nil {
+		return nil, err
+	}
+	for _, d := range c.Days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid day '%s', expecting one of sun, mon, tue, wed, thu, fri or sat", d)
+		}
+		w.days[wd] = true
+	}
+	if len(c.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses a time of day like 22:00 into a duration from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day '%s', expecting hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// matches tells whether the window applies to the given probe.
+func (w *window) matches(pn string, labels map[string]string) bool {
+	if len(w.c.Probes) > 0 {
+		matched := false
+		for _, g := range w.c.Probes {
+			if ok, _ := path.Match(g, pn); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, v := range w.c.Labels {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// active tells whether the window is on at the given time.
+func (w *window) active(now time.Time) bool {
+	now = now.In(w.loc)
+	if w.cron != nil {
+		// the window is on if it started within the last duration.
+		start := w.cron.Next(now.Add(-w.duration))
+		return !start.IsZero() && !start.After(now)
+	}
+	tod := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	if w.start < w.end {
+		return w.days[now.Weekday()] && tod >= w.start && tod < w.end
+	}
+	// the range ends the next day.
+	return (w.days[now.Weekday()] && tod >= w.start) || (w.days[(now.Weekday()+6)%7] && tod < w.end)
+}
+
+// Window returns the name and the mode of the maintenance window the given probe is in at the given time, or empty
+// strings if none. A window in skip mode wins over one in exclude mode.
+func (m *Maintenance) Window(pn string, labels map[string]string, now time.Time) (name, mode string) {
+	if m == nil {
+		return "", ""
+	}
+	for _, w := range m.windows {
+		if !w.matches(pn, labels) || !w.active(now) {
+			continue
+		}
+		if w.c.Mode == ModeSkip {
+			return w.c.Name, w.c.Mode
+		}
+		if name == "" {
+			name, mode = w.c.Name, w.c.Mode
+		}
+	}
+	return name, mode
+}
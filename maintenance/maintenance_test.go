@@ -0,0 +1,95 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetup(t *testing.T) {
+	m, err := Setup(nil)
+	if m != nil || err != nil {
+		t.Errorf("Got: %v, %v\n Want: nil, nil", m, err)
+	}
+	if name, mode := m.Window("probe1", nil, time.Now()); name != "" || mode != "" {
+		t.Errorf("Got: %s, %s from a nil maintenance", name, mode)
+	}
+
+	m, err = Setup([]byte(`[
+		{"name": "patching", "cron": "0 2 * * sun", "duration": "2h", "time_zone": "Europe/Paris", "probes": ["db_*"]},
+		{"name": "nights", "days": ["Mon", "fri"], "start": "22:00", "end": "06:00", "labels": {"env": "staging"}, "mode": "exclude"}
+	]`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(m.windows) != 2 {
+		t.Errorf("Got %d windows\n Want 2", len(m.windows))
+	}
+
+	for _, config := range []string{
+		`[{"cron": "0 2 * * *", "duration": "1h", "probes": ["*"]}]`,
+		`[{"name": "w", "cron": "0 2 * * *", "duration": "1h", "probes": ["*"]}, {"name": "w", "start": "01:00", "end": "02:00", "probes": ["*"]}]`,
+		`[{"name": "w", "cron": "0 2 * * *", "duration": "1h"}]`,
+		`[{"name": "w", "cron": "0 2 * * *", "duration": "1h", "probes": ["["]}]`,
+		`[{"name": "w", "cron": "0 25 * * *", "duration": "1h", "probes": ["*"]}]`,
+		`[{"name": "w", "cron": "0 2 * * *", "probes": ["*"]}]`,
+		`[{"name": "w", "cron": "0 2 * * *", "duration": "1h", "start": "01:00", "probes": ["*"]}]`,
+		`[{"name": "w", "start": "01:00", "probes": ["*"]}]`,
+		`[{"name": "w", "start": "1am", "end": "02:00", "probes": ["*"]}]`,
+		`[{"name": "w", "days": ["someday"], "start": "01:00", "end": "02:00", "probes": ["*"]}]`,
+		`[{"name": "w", "start": "01:00", "end": "02:00", "time_zone": "Mars/Olympus", "probes": ["*"]}]`,
+		`[{"name": "w", "start": "01:00", "end": "02:00", "probes": ["*"], "mode": "ignore"}]`,
+	} {
+		if _, err = Setup([]byte(config)); err == nil {
+			t.Errorf("%s: Expecting error, but test is passing", config)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	m, err := New([]WindowConfig{
+		{Name: "patching", Cron: "0 2 * * sun", Duration: "2h", TimeZone: "Europe/Paris", Probes: []string{"db_*"}},
+		{Name: "nights", Days: []string{"fri"}, Start: "22:00", End: "06:00", Labels: map[string]string{"env": "staging"}, Mode: ModeExclude},
+		{Name: "staging_db", Days: []string{"sat"}, Start: "05:00", End: "07:00", Probes: []string{"db_*"}, Labels: map[string]string{"env": "staging"}},
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	staging := map[string]string{"env": "staging"}
+	tests := []struct {
+		pn     string
+		labels map[string]string
+		now    time.Time
+		name   string
+	}{
+		// sunday 2016-01-31, 02:00 to 04:00 in Paris.
+		{"db_main", nil, time.Date(2016, 1, 31, 2, 0, 0, 0, paris), "patching"},
+		{"db_main", nil, time.Date(2016, 1, 31, 3, 59, 59, 0, paris), "patching"},
+		{"db_main", nil, time.Date(2016, 1, 31, 4, 0, 0, 0, paris), ""},
+		{"db_main", nil, time.Date(2016, 1, 31, 1, 30, 0, 0, time.UTC), "patching"},
+		{"db_main", nil, time.Date(2016, 1, 31, 3, 30, 0, 0, time.UTC), ""},
+		{"web", nil, time.Date(2016, 1, 31, 3, 0, 0, 0, paris), ""},
+		// friday 2016-01-29 22:00 to saturday 06:00 UTC.
+		{"web", staging, time.Date(2016, 1, 29, 21, 59, 0, 0, time.UTC), ""},
+		{"web", staging, time.Date(2016, 1, 29, 22, 0, 0, 0, time.UTC), "nights"},
+		{"web", staging, time.Date(2016, 1, 30, 5, 59, 0, 0, time.UTC), "nights"},
+		{"web", staging, time.Date(2016, 1, 30, 6, 0, 0, 0, time.UTC), ""},
+		{"web", staging, time.Date(2016, 1, 30, 22, 30, 0, 0, time.UTC), ""},
+		{"web", map[string]string{"env": "prod"}, time.Date(2016, 1, 29, 23, 0, 0, 0, time.UTC), ""},
+		// in both nights and staging_db, the skip window wins.
+		{"db_main", staging, time.Date(2016, 1, 30, 5, 30, 0, 0, time.UTC), "staging_db"},
+		{"db_main", staging, time.Date(2016, 1, 30, 4, 30, 0, 0, time.UTC), "nights"},
+	}
+	for _, tt := range tests {
+		name, mode := m.Window(tt.pn, tt.labels, tt.now)
+		if name != tt.name {
+			t.Errorf("%s %v at %v: Got: %s\n Want: %s", tt.pn, tt.labels, tt.now, name, tt.name)
+		}
+		if name == "nights" && mode != ModeExclude || name == "staging_db" && mode != ModeSkip {
+			t.Errorf("%s: Got mode: %s", name, mode)
+		}
+	}
+}
@@ -2,11 +2,21 @@ package metric_export
 
 import (
 	"errors"
-	"github.com/marpaia/graphite-golang"
+	"flag"
 	"github.com/samitpal/goProbe/modules"
 	"net/http"
 )
 
+// Values of the result label of the probe result counter.
+const (
+	ResultSuccess = "success" // probe ran and reported the target as up.
+	ResultFailure = "failure" // probe ran and reported the target as down.
+	ResultError   = "error"   // probe module error'ed out or returned an invalid response.
+	ResultTimeout = "timeout" // probe did not return within its timeout.
+)
+
+var cleanMetrics = flag.Bool("clean_metrics", false, "Do not use -1 sentinel values on probe errors/timeouts. 'up' is set to 0 while latency and payload size are dropped.")
+
 // MetricExporter interface is implemented by an exporter which wants to expose the probe metrics in its own format.
 type MetricExporter interface {
 	// Prepare should be used for initialization. It is guranteed to be called first, before any another methods.
@@ -21,21 +31,96 @@ type MetricExporter interface {
 	// IncTimeoutCount increments the timeout count of a given probe.  It takes the probe name and epoch time (seconds) as args.
 	IncProbeTimeoutCount(string, int64)
 
+	// IncProbeResultCount increments the result count of a given probe. It takes the probe name, the result
+	// (one of ResultSuccess, ResultFailure, ResultError, ResultTimeout) and epoch time (seconds) as args.
+	IncProbeResultCount(string, string, int64)
+
 	// SetFieldValues function sets the field values during normal times, e.g set the ‘up’ variable to 1 or 0.
 	// It takes the probe name, probe response and epoch time (seconds) as args.
 	SetFieldValues(string, *modules.ProbeData, int64)
 
 	// SetFieldValuesUnexpected function sets field values during unexpected situations, e.g probe errors/timeouts. For instance
-	// one might want to set the ‘up’ variable for a probe which timed out to -1 instead of a 0 or 1.
+	// one might want to set the ‘up’ variable for a probe which timed out to -1 instead of a 0 or 1. With -clean_metrics
+	// set, ‘up’ is set to 0 and the latency and payload size are dropped instead.
 	// It takes the probe name and epoch time (seconds) as args.
 	SetFieldValuesUnexpected(string, int64)
 
+	// SetProbeState sets the debounced state of a given probe, e.g up, down or flapping. It takes the probe name,
+	// the state and epoch time (seconds) as args.
+	SetProbeState(string, string, int64)
+
+	// SetProbeAttempts records the number of attempts of a probe run, more than 1 if the run was retried, see the
+	// retries probe option. It takes the probe name, the number of attempts and epoch time (seconds) as args.
+	SetProbeAttempts(string, int, int64)
+
+	// SetProbeFlag sets whether a given flag, one of ProbeFlags, is set for a given probe, e.g whether it is paused.
+	// It takes the probe name, the flag, whether it is set and epoch time (seconds) as args.
+	SetProbeFlag(string, string, bool, int64)
+
+	// SetConfigReloadStatus records the outcome of the last probe config (re)load. It takes whether the load
+	// was successful and the epoch time (seconds) of the load as args.
+	SetConfigReloadStatus(bool, int64)
+
 	// MetricHttpHandler returns the http handler to expose the metrics via a given path (e.g /metrics).
 	MetricHttpHandler() http.Handler
 
-	// RetGraphiteMetrics is only implemented by the json exporter. This will be used for pushing metrics
-	// It returns the metrics for a given probe in graphite metric format
-	RetGraphiteMetrics(string) []graphite.Metric
+	// Snapshot returns the current metrics of the given probe in an exposition format neutral form. This is used
+	// by the push providers, which format the samples on their own.
+	Snapshot(string) []Sample
+
+	// Restore sets the metrics of the given probe from samples returned by Snapshot, e.g as saved before a restart.
+	// The counters are increased by the sample values, hence it should be called before any probe run.
+	Restore(string, []Sample)
+
+	// AddSelfMetric adds a metric about goProbe itself, e.g the push queue depth, to the exposed metrics.
+	AddSelfMetric(SelfMetric)
+}
+
+// The probe flags, see SetProbeFlag.
+const (
+	FlagPaused        = "paused"
+	FlagSilenced      = "silenced"
+	FlagInMaintenance = "in_maintenance"
+)
+
+// ProbeFlag describes a probe flag. Each flag is exposed as a metric of its own, e.g probe_paused, whose value is 1
+// if the flag is set and 0 otherwise.
+type ProbeFlag struct {
+	Name string
+	Help string
+}
+
+// ProbeFlags lists the probe flags.
+var ProbeFlags = []ProbeFlag{
+	{FlagPaused, "Whether the probe is paused, i.e not run."},
+	{FlagSilenced, "Whether the probe is silenced, i.e run without its state changes being notified."},
+	{FlagInMaintenance, "Whether the probe is in a maintenance window."},
+}
+
+// Sample is a single metric value of a probe.
+type Sample struct {
+	Name      string            `json:"name"`      // metric name without any prefix, e.g count, error_count, up, latency.
+	Value     float64           `json:"value"`     // metric value.
+	Timestamp int64             `json:"timestamp"` // epoch time (seconds) of the probe run which last updated the metric.
+	Labels    map[string]string `json:"labels"`    // the probe_name label, along with the result label for the result metric and the state label for the state metric.
+}
+
+// ProbeSamples holds the samples of a probe run along with the probe labels from the config. This is what the push
+// providers push.
+type ProbeSamples struct {
+	ProbeName string            `json:"probe_name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Samples   []Sample          `json:"samples"`
+}
+
+// SelfMetric is a metric about goProbe itself rather than about a probe. Its value is read through the Value
+// function whenever the metrics are exposed.
+type SelfMetric struct {
+	Name    string            // metric name without any prefix, e.g push_queue_depth.
+	Help    string            // metric description.
+	Counter bool              // whether the metric is a counter. It is a gauge otherwise.
+	Labels  map[string]string // optional constant labels, e.g the push provider name.
+	Value   func() float64
 }
 
 func SetupMetricExporter(s string) (MetricExporter, error) {
@@ -2,10 +2,9 @@ package metric_export
 
 import (
 	"encoding/json"
-	grpt "github.com/marpaia/graphite-golang"
 	"github.com/samitpal/goProbe/modules"
 	"net/http"
-	"strconv"
+	"sort"
 	"sync"
 )
 
@@ -29,6 +28,16 @@ type ProbeTimeoutCount struct {
 	TimeoutCount map[string]TimeValue `json:"probe_timeout_count"`
 }
 
+type ProbeResultCount struct {
+	sync.RWMutex
+	ResultCount map[string]map[string]TimeValue `json:"probe_result_count"` // keyed by probe name and then by result.
+}
+
+type ProbeState struct {
+	sync.RWMutex
+	State map[string]map[string]TimeValue `json:"probe_state"` // keyed by probe name and then by state. Value of 1 for the current state, 0 for the others.
+}
+
 type ProbeIsUp struct {
 	sync.RWMutex
 	Up map[string]TimeValue `json:"probe_is_up"` // value of 1 is a success while 0 is a failure.
@@ -44,25 +53,69 @@ type ProbePayloadSize struct {
 	Payload map[string]TimeValue `json:"probe_payload_size"`
 }
 
+type ProbeAttempts struct {
+	sync.RWMutex
+	Attempts map[string]TimeValue `json:"probe_attempts"` // the number of attempts of the last run.
+}
+
+type ProbeRetryCount struct {
+	sync.RWMutex
+	RetryCount map[string]TimeValue `json:"probe_retry_count"` // the number of attempts beyond the first one of all the runs.
+}
+
+type ProbeFlagValues struct {
+	sync.RWMutex
+	Flags map[string]map[string]TimeValue // keyed by flag and then by probe name. Value of 1 if the flag is set, 0 otherwise.
+}
+
+type ConfigReload struct {
+	sync.RWMutex
+	Success          TimeValue `json:"config_last_reload_success"`           // value of 1 if the last config load was a success, 0 otherwise.
+	SuccessTimestamp int64     `json:"config_last_reload_success_timestamp"` // epoch time (seconds) of the last successful config load.
+}
+
+type SelfMetrics struct {
+	sync.RWMutex
+	Metrics []SelfMetric
+}
+
 type jsonExport struct {
 	ProbeCount
 	ProbeErrorCount   // error count indicates error in probe module.
 	ProbeTimeoutCount // timeout count increases when a probe times out.
+	ProbeResultCount  // count of each of the probe results, i.e success, failure, error and timeout.
+	ProbeState        // the debounced state of the probes, e.g up, down or flapping.
 	ProbeIsUp         // value of 1 is a success, 0 is failure. value of -1 could be because of probe module failure/timeout.
 	ProbeLatency      // latency in milli seconds.
 	ProbePayloadSize  // size of the response payload.
+	ProbeAttempts     // number of attempts of the last run, more than 1 if it was retried.
+	ProbeRetryCount   // number of retried attempts.
+	ProbeFlagValues   // the probe flags, e.g whether a probe is paused.
+	ConfigReload      // outcome of the last config load.
+	SelfMetrics       // metrics about goProbe itself, e.g push queue depth.
+
+	clean bool // if set, no -1 sentinel values are used. See the clean_metrics flag.
 }
 
 func NewJSONExport() *jsonExport {
-	return &jsonExport{
+	pm := &jsonExport{
 		ProbeCount:        ProbeCount{Count: make(map[string]TimeValue)},
 		ProbeErrorCount:   ProbeErrorCount{ErrorCount: make(map[string]TimeValue)},
 		ProbeTimeoutCount: ProbeTimeoutCount{TimeoutCount: make(map[string]TimeValue)},
+		ProbeResultCount:  ProbeResultCount{ResultCount: make(map[string]map[string]TimeValue)},
+		ProbeState:        ProbeState{State: make(map[string]map[string]TimeValue)},
 		ProbeIsUp:         ProbeIsUp{Up: make(map[string]TimeValue)},
 		ProbeLatency:      ProbeLatency{Latency: make(map[string]TimeValue)},
 		ProbePayloadSize:  ProbePayloadSize{Payload: make(map[string]TimeValue)},
+		ProbeAttempts:     ProbeAttempts{Attempts: make(map[string]TimeValue)},
+		ProbeRetryCount:   ProbeRetryCount{RetryCount: make(map[string]TimeValue)},
+		ProbeFlagValues:   ProbeFlagValues{Flags: make(map[string]map[string]TimeValue)},
+		clean:             *cleanMetrics,
 	}
-
+	for _, f := range ProbeFlags {
+		pm.ProbeFlagValues.Flags[f.Name] = make(map[string]TimeValue)
+	}
+	return pm
 }
 
 func (pm *jsonExport) Prepare() {
@@ -108,6 +161,17 @@ func (pm *jsonExport) IncProbeTimeoutCount(s string, t int64) {
 	pm.ProbeTimeoutCount.Unlock()
 }
 
+func (pm *jsonExport) IncProbeResultCount(s string, r string, t int64) {
+	pm.ProbeResultCount.Lock()
+	results, ok := pm.ProbeResultCount.ResultCount[s]
+	if !ok {
+		results = make(map[string]TimeValue)
+		pm.ProbeResultCount.ResultCount[s] = results
+	}
+	results[r] = TimeValue{Value: results[r].Value + 1, Time: t}
+	pm.ProbeResultCount.Unlock()
+}
+
 func (pm *jsonExport) SetFieldValues(s string, pd *modules.ProbeData, t int64) {
 	pm.ProbeIsUp.Lock()
 	pm.ProbeIsUp.Up[s] = TimeValue{Value: *pd.IsUp, Time: t}
@@ -125,7 +189,23 @@ func (pm *jsonExport) SetFieldValues(s string, pd *modules.ProbeData, t int64) {
 }
 
 // SetFieldValuesUnexpected sets values to the fields to -1 to indicate a probe module error/timeout.
+// In clean mode 'up' is set to 0 while the latency and payload size are removed.
 func (pm *jsonExport) SetFieldValuesUnexpected(s string, t int64) {
+	if pm.clean {
+		pm.ProbeIsUp.Lock()
+		pm.ProbeIsUp.Up[s] = TimeValue{Value: 0, Time: t}
+		pm.ProbeIsUp.Unlock()
+
+		pm.ProbeLatency.Lock()
+		delete(pm.ProbeLatency.Latency, s)
+		pm.ProbeLatency.Unlock()
+
+		pm.ProbePayloadSize.Lock()
+		delete(pm.ProbePayloadSize.Payload, s)
+		pm.ProbePayloadSize.Unlock()
+		return
+	}
+
 	pm.ProbeIsUp.Lock()
 	pm.ProbeIsUp.Up[s] = TimeValue{Value: -1, Time: t}
 	pm.ProbeIsUp.Unlock()
@@ -139,6 +219,59 @@ func (pm *jsonExport) SetFieldValuesUnexpected(s string, t int64) {
 	pm.ProbePayloadSize.Unlock()
 }
 
+func (pm *jsonExport) SetProbeState(s string, state string, t int64) {
+	pm.ProbeState.Lock()
+	states, ok := pm.ProbeState.State[s]
+	if !ok {
+		states = make(map[string]TimeValue)
+		pm.ProbeState.State[s] = states
+	}
+	for st := range states {
+		states[st] = TimeValue{Value: 0, Time: t}
+	}
+	states[state] = TimeValue{Value: 1, Time: t}
+	pm.ProbeState.Unlock()
+}
+
+func (pm *jsonExport) SetProbeAttempts(s string, attempts int, t int64) {
+	pm.ProbeAttempts.Lock()
+	pm.ProbeAttempts.Attempts[s] = TimeValue{Value: float64(attempts), Time: t}
+	pm.ProbeAttempts.Unlock()
+
+	pm.ProbeRetryCount.Lock()
+	pm.ProbeRetryCount.RetryCount[s] = TimeValue{Value: pm.ProbeRetryCount.RetryCount[s].Value + float64(attempts-1), Time: t}
+	pm.ProbeRetryCount.Unlock()
+}
+
+func (pm *jsonExport) SetProbeFlag(s string, flag string, set bool, t int64) {
+	tv := TimeValue{Value: 0, Time: t}
+	if set {
+		tv.Value = 1
+	}
+	pm.ProbeFlagValues.Lock()
+	if flags, ok := pm.ProbeFlagValues.Flags[flag]; ok {
+		flags[s] = tv
+	}
+	pm.ProbeFlagValues.Unlock()
+}
+
+func (pm *jsonExport) SetConfigReloadStatus(success bool, t int64) {
+	pm.ConfigReload.Lock()
+	if success {
+		pm.ConfigReload.Success = TimeValue{Value: 1, Time: t}
+		pm.ConfigReload.SuccessTimestamp = t
+	} else {
+		pm.ConfigReload.Success = TimeValue{Value: 0, Time: t}
+	}
+	pm.ConfigReload.Unlock()
+}
+
+func (pm *jsonExport) AddSelfMetric(sm SelfMetric) {
+	pm.SelfMetrics.Lock()
+	pm.SelfMetrics.Metrics = append(pm.SelfMetrics.Metrics, sm)
+	pm.SelfMetrics.Unlock()
+}
+
 func jsonHttpHandler(pm *jsonExport) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		dst, err := json.MarshalIndent(pm, "", " ")
@@ -172,6 +305,14 @@ func (pm *jsonExport) MarshalJSON() ([]byte, error) {
 	m["probe_timeout_count"] = pm.ProbeTimeoutCount.TimeoutCount
 	pm.ProbeTimeoutCount.RUnlock()
 
+	pm.ProbeResultCount.RLock()
+	m["probe_result_count"] = pm.ProbeResultCount.ResultCount
+	pm.ProbeResultCount.RUnlock()
+
+	pm.ProbeState.RLock()
+	m["probe_state"] = pm.ProbeState.State
+	pm.ProbeState.RUnlock()
+
 	pm.ProbeIsUp.RLock()
 	m["probe_up"] = pm.ProbeIsUp.Up
 	pm.ProbeIsUp.RUnlock()
@@ -184,50 +325,181 @@ func (pm *jsonExport) MarshalJSON() ([]byte, error) {
 	m["probe_payload_size"] = pm.ProbePayloadSize.Payload
 	pm.ProbePayloadSize.RUnlock()
 
+	pm.ProbeAttempts.RLock()
+	m["probe_attempts"] = pm.ProbeAttempts.Attempts
+	pm.ProbeAttempts.RUnlock()
+
+	pm.ProbeRetryCount.RLock()
+	m["probe_retry_count"] = pm.ProbeRetryCount.RetryCount
+	pm.ProbeRetryCount.RUnlock()
+
+	pm.ProbeFlagValues.RLock()
+	for f, values := range pm.ProbeFlagValues.Flags {
+		m["probe_"+f] = values
+	}
+	pm.ProbeFlagValues.RUnlock()
+
+	pm.ConfigReload.RLock()
+	m["config_last_reload_success"] = pm.ConfigReload.Success
+	m["config_last_reload_success_timestamp"] = pm.ConfigReload.SuccessTimestamp
+	pm.ConfigReload.RUnlock()
+
+	// self metrics are keyed by name, each with the list of values of its label sets.
+	self := make(map[string][]map[string]interface{})
+	pm.SelfMetrics.RLock()
+	for _, sm := range pm.SelfMetrics.Metrics {
+		self[sm.Name] = append(self[sm.Name], map[string]interface{}{"labels": sm.Labels, "value": sm.Value()})
+	}
+	pm.SelfMetrics.RUnlock()
+	m["self_metrics"] = self
+
 	return json.Marshal(m)
 }
 
-func (pm *jsonExport) RetGraphiteMetrics(pn string) []grpt.Metric {
-	var metric []grpt.Metric
+// Snapshot returns the current metrics of the given probe.
+func (pm *jsonExport) Snapshot(pn string) []Sample {
+	var samples []Sample
+	add := func(name string, tv TimeValue, ok bool) {
+		if ok {
+			samples = append(samples, Sample{Name: name, Value: tv.Value, Timestamp: tv.Time, Labels: map[string]string{"probe_name": pn}})
+		}
+	}
 
 	pm.ProbeCount.RLock()
-	pc_metric := grpt.Metric{Name: pn + ".count", Value: strconv.FormatFloat(pm.ProbeCount.Count[pn].Value, 'g', -1, 64), Timestamp: pm.ProbeCount.Count[pn].Time}
-	metric = append(metric, pc_metric)
+	tv, ok := pm.ProbeCount.Count[pn]
+	add("count", tv, ok)
 	pm.ProbeCount.RUnlock()
 
 	pm.ProbeErrorCount.RLock()
-	_, ok := pm.ProbeErrorCount.ErrorCount[pn]
-	if ok {
-		pe_metric := grpt.Metric{Name: pn + ".error_count", Value: strconv.FormatFloat(pm.ProbeErrorCount.ErrorCount[pn].Value, 'g', -1, 64), Timestamp: pm.ProbeErrorCount.ErrorCount[pn].Time}
-		metric = append(metric, pe_metric)
-	}
+	tv, ok = pm.ProbeErrorCount.ErrorCount[pn]
+	add("error_count", tv, ok)
 	pm.ProbeErrorCount.RUnlock()
 
 	pm.ProbeTimeoutCount.RLock()
-	_, ok = pm.ProbeTimeoutCount.TimeoutCount[pn]
-	if ok {
-		pt_metric := grpt.Metric{Name: pn + ".timeout_count", Value: strconv.FormatFloat(pm.ProbeTimeoutCount.TimeoutCount[pn].Value, 'g', -1, 64), Timestamp: pm.ProbeTimeoutCount.TimeoutCount[pn].Time}
-		metric = append(metric, pt_metric)
-	}
+	tv, ok = pm.ProbeTimeoutCount.TimeoutCount[pn]
+	add("timeout_count", tv, ok)
 	pm.ProbeTimeoutCount.RUnlock()
 
+	pm.ProbeResultCount.RLock()
+	results := pm.ProbeResultCount.ResultCount[pn]
+	for _, r := range sortedKeys(results) {
+		tv := results[r]
+		samples = append(samples, Sample{Name: "result", Value: tv.Value, Timestamp: tv.Time, Labels: map[string]string{"probe_name": pn, "result": r}})
+	}
+	pm.ProbeResultCount.RUnlock()
+
+	pm.ProbeState.RLock()
+	states := pm.ProbeState.State[pn]
+	for _, st := range sortedKeys(states) {
+		tv := states[st]
+		samples = append(samples, Sample{Name: "state", Value: tv.Value, Timestamp: tv.Time, Labels: map[string]string{"probe_name": pn, "state": st}})
+	}
+	pm.ProbeState.RUnlock()
+
 	pm.ProbeIsUp.RLock()
-	pu_metric := grpt.Metric{Name: pn + ".up", Value: strconv.FormatFloat(pm.ProbeIsUp.Up[pn].Value, 'g', -1, 64), Timestamp: pm.ProbeIsUp.Up[pn].Time}
-	metric = append(metric, pu_metric)
+	tv, ok = pm.ProbeIsUp.Up[pn]
+	add("up", tv, ok)
 	pm.ProbeIsUp.RUnlock()
 
 	pm.ProbeLatency.RLock()
-	pl_metric := grpt.Metric{Name: pn + ".latency", Value: strconv.FormatFloat(pm.ProbeLatency.Latency[pn].Value, 'g', -1, 64), Timestamp: pm.ProbeLatency.Latency[pn].Time}
-	metric = append(metric, pl_metric)
+	tv, ok = pm.ProbeLatency.Latency[pn]
+	add("latency", tv, ok)
 	pm.ProbeLatency.RUnlock()
 
 	pm.ProbePayloadSize.RLock()
-	_, ok = pm.ProbePayloadSize.Payload[pn]
-	if ok {
-		ps_metric := grpt.Metric{Name: pn + ".payload_size", Value: strconv.FormatFloat(pm.ProbePayloadSize.Payload[pn].Value, 'g', -1, 64), Timestamp: pm.ProbePayloadSize.Payload[pn].Time}
-		metric = append(metric, ps_metric)
-	}
+	tv, ok = pm.ProbePayloadSize.Payload[pn]
+	add("payload_size", tv, ok)
 	pm.ProbePayloadSize.RUnlock()
 
-	return metric
+	pm.ProbeAttempts.RLock()
+	tv, ok = pm.ProbeAttempts.Attempts[pn]
+	add("attempts", tv, ok)
+	pm.ProbeAttempts.RUnlock()
+
+	pm.ProbeRetryCount.RLock()
+	tv, ok = pm.ProbeRetryCount.RetryCount[pn]
+	add("retry_count", tv, ok)
+	pm.ProbeRetryCount.RUnlock()
+
+	pm.ProbeFlagValues.RLock()
+	for _, f := range ProbeFlags {
+		tv, ok = pm.ProbeFlagValues.Flags[f.Name][pn]
+		add(f.Name, tv, ok)
+	}
+	pm.ProbeFlagValues.RUnlock()
+
+	return samples
+}
+
+// sortedKeys returns the keys of the given values in order, so that the snapshots list the samples in the same order.
+func sortedKeys(m map[string]TimeValue) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Restore sets the metrics of the given probe from the given samples. The counters are increased by the sample
+// values while the other metrics are set to them.
+func (pm *jsonExport) Restore(pn string, samples []Sample) {
+	add := func(m map[string]TimeValue, s Sample) {
+		m[pn] = TimeValue{Value: m[pn].Value + s.Value, Time: s.Timestamp}
+	}
+	for _, s := range samples {
+		switch s.Name {
+		case "count":
+			pm.ProbeCount.Lock()
+			add(pm.ProbeCount.Count, s)
+			pm.ProbeCount.Unlock()
+		case "error_count":
+			pm.ProbeErrorCount.Lock()
+			add(pm.ProbeErrorCount.ErrorCount, s)
+			pm.ProbeErrorCount.Unlock()
+		case "timeout_count":
+			pm.ProbeTimeoutCount.Lock()
+			add(pm.ProbeTimeoutCount.TimeoutCount, s)
+			pm.ProbeTimeoutCount.Unlock()
+		case "result":
+			pm.ProbeResultCount.Lock()
+			results, ok := pm.ProbeResultCount.ResultCount[pn]
+			if !ok {
+				results = make(map[string]TimeValue)
+				pm.ProbeResultCount.ResultCount[pn] = results
+			}
+			r := s.Labels["result"]
+			results[r] = TimeValue{Value: results[r].Value + s.Value, Time: s.Timestamp}
+			pm.ProbeResultCount.Unlock()
+		case "state":
+			pm.ProbeState.Lock()
+			states, ok := pm.ProbeState.State[pn]
+			if !ok {
+				states = make(map[string]TimeValue)
+				pm.ProbeState.State[pn] = states
+			}
+			states[s.Labels["state"]] = TimeValue{Value: s.Value, Time: s.Timestamp}
+			pm.ProbeState.Unlock()
+		case "up":
+			pm.ProbeIsUp.Lock()
+			pm.ProbeIsUp.Up[pn] = TimeValue{Value: s.Value, Time: s.Timestamp}
+			pm.ProbeIsUp.Unlock()
+		case "latency":
+			pm.ProbeLatency.Lock()
+			pm.ProbeLatency.Latency[pn] = TimeValue{Value: s.Value, Time: s.Timestamp}
+			pm.ProbeLatency.Unlock()
+		case "payload_size":
+			pm.ProbePayloadSize.Lock()
+			pm.ProbePayloadSize.Payload[pn] = TimeValue{Value: s.Value, Time: s.Timestamp}
+			pm.ProbePayloadSize.Unlock()
+		case "attempts":
+			pm.ProbeAttempts.Lock()
+			pm.ProbeAttempts.Attempts[pn] = TimeValue{Value: s.Value, Time: s.Timestamp}
+			pm.ProbeAttempts.Unlock()
+		case "retry_count":
+			pm.ProbeRetryCount.Lock()
+			add(pm.ProbeRetryCount.RetryCount, s)
+			pm.ProbeRetryCount.Unlock()
+		}
+	}
 }
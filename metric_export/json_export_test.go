@@ -65,3 +65,163 @@ func TestSetFieldValuesUnexpected(t *testing.T) {
 		t.Errorf("Got: %v\n Want: %v", je.ProbePayloadSize, probe1PayloadSize)
 	}
 }
+
+func TestSetFieldValuesUnexpectedClean(t *testing.T) {
+	up := float64(1)
+	ps := float64(45)
+	st := int64(567)
+	et := int64(890)
+	lt := float64(123)
+
+	pd := modules.ProbeData{
+		IsUp:        &up,
+		Latency:     &lt,
+		StartTime:   &st,
+		EndTime:     &et,
+		PayloadSize: &ps,
+	}
+	pn := "probe1"
+	je := NewJSONExport()
+	je.clean = true
+	epochTime := time.Now().Unix()
+	je.SetFieldValues(pn, &pd, epochTime)
+	je.SetFieldValuesUnexpected(pn, epochTime)
+
+	probe1Up := map[string]TimeValue{"probe1": TimeValue{0, epochTime}}
+	if !reflect.DeepEqual(probe1Up, je.ProbeIsUp.Up) {
+		t.Errorf("Got: %v\n Want: %v", je.ProbeIsUp.Up, probe1Up)
+	}
+
+	if _, ok := je.ProbeLatency.Latency[pn]; ok {
+		t.Errorf("Latency of %s should have been removed, got: %v", pn, je.ProbeLatency.Latency)
+	}
+
+	if _, ok := je.ProbePayloadSize.Payload[pn]; ok {
+		t.Errorf("Payload size of %s should have been removed, got: %v", pn, je.ProbePayloadSize.Payload)
+	}
+}
+
+func TestIncProbeResultCount(t *testing.T) {
+	pn := "probe1"
+	je := NewJSONExport()
+	epochTime := time.Now().Unix()
+	je.IncProbeResultCount(pn, ResultSuccess, epochTime)
+	je.IncProbeResultCount(pn, ResultSuccess, epochTime)
+	je.IncProbeResultCount(pn, ResultTimeout, epochTime)
+
+	want := map[string]map[string]TimeValue{"probe1": {ResultSuccess: TimeValue{2, epochTime}, ResultTimeout: TimeValue{1, epochTime}}}
+	if !reflect.DeepEqual(want, je.ProbeResultCount.ResultCount) {
+		t.Errorf("Got: %v\n Want: %v", je.ProbeResultCount.ResultCount, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	up := float64(1)
+	lt := float64(123)
+	pd := modules.ProbeData{IsUp: &up, Latency: &lt}
+	pn := "probe1"
+
+	je := NewJSONExport()
+	je.IncProbeCount(pn, 90)
+	je.IncProbeResultCount(pn, ResultTimeout, 90)
+	je.IncProbeTimeoutCount(pn, 90)
+	je.SetProbeState(pn, "down", 90)
+	je.IncProbeCount(pn, 100)
+	je.IncProbeResultCount(pn, ResultSuccess, 100)
+	je.SetFieldValues(pn, &pd, 100)
+	je.SetProbeState(pn, "up", 100)
+	je.IncProbeCount("probe2", 200)
+
+	// the results and states are listed in order, whatever the order of the runs.
+	want := []Sample{
+		{Name: "count", Value: 2, Timestamp: 100, Labels: map[string]string{"probe_name": pn}},
+		{Name: "timeout_count", Value: 1, Timestamp: 90, Labels: map[string]string{"probe_name": pn}},
+		{Name: "result", Value: 1, Timestamp: 100, Labels: map[string]string{"probe_name": pn, "result": ResultSuccess}},
+		{Name: "result", Value: 1, Timestamp: 90, Labels: map[string]string{"probe_name": pn, "result": ResultTimeout}},
+		{Name: "state", Value: 0, Timestamp: 100, Labels: map[string]string{"probe_name": pn, "state": "down"}},
+		{Name: "state", Value: 1, Timestamp: 100, Labels: map[string]string{"probe_name": pn, "state": "up"}},
+		{Name: "up", Value: 1, Timestamp: 100, Labels: map[string]string{"probe_name": pn}},
+		{Name: "latency", Value: 123, Timestamp: 100, Labels: map[string]string{"probe_name": pn}},
+	}
+	for i := 0; i < 10; i++ {
+		if got := je.Snapshot(pn); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Got: %v\n Want: %v", got, want)
+		}
+	}
+}
+
+func TestSetProbeState(t *testing.T) {
+	je := NewJSONExport()
+	je.SetProbeState("probe1", "up", 100)
+	je.SetProbeState("probe1", "down", 160)
+
+	want := map[string]TimeValue{"up": {Value: 0, Time: 160}, "down": {Value: 1, Time: 160}}
+	if !reflect.DeepEqual(je.ProbeState.State["probe1"], want) {
+		t.Errorf("Got: %v\n Want: %v", je.ProbeState.State["probe1"], want)
+	}
+}
+
+func TestSetProbeFlag(t *testing.T) {
+	je := NewJSONExport()
+	je.SetProbeFlag("probe1", FlagPaused, true, 100)
+	je.SetProbeFlag("probe1", FlagSilenced, true, 100)
+	je.SetProbeFlag("probe1", FlagSilenced, false, 160)
+	je.SetProbeFlag("probe1", "invalid", true, 160)
+
+	want := []Sample{
+		{Name: FlagPaused, Value: 1, Timestamp: 100, Labels: map[string]string{"probe_name": "probe1"}},
+		{Name: FlagSilenced, Value: 0, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+	}
+	if got := je.Snapshot("probe1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestSetProbeAttempts(t *testing.T) {
+	je := NewJSONExport()
+	je.SetProbeAttempts("probe1", 3, 100)
+	je.SetProbeAttempts("probe1", 1, 160)
+	saved := je.Snapshot("probe1")
+
+	want := []Sample{
+		{Name: "attempts", Value: 1, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+		{Name: "retry_count", Value: 2, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+	}
+	if !reflect.DeepEqual(saved, want) {
+		t.Errorf("Got: %v\n Want: %v", saved, want)
+	}
+
+	// the retry count is a counter.
+	je = NewJSONExport()
+	je.Restore("probe1", saved)
+	je.SetProbeAttempts("probe1", 2, 200)
+	if got := je.ProbeRetryCount.RetryCount["probe1"]; got.Value != 3 || got.Time != 200 {
+		t.Errorf("Got retry count: %v\n Want: 3 at 200", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	pe := NewJSONExport()
+	pe.IncProbeCount("probe1", 100)
+	pe.IncProbeErrorCount("probe1", 100)
+	pe.IncProbeResultCount("probe1", ResultError, 100)
+	pe.SetProbeState("probe1", "down", 100)
+	saved := pe.Snapshot("probe1")
+
+	pe = NewJSONExport()
+	pe.Restore("probe1", saved)
+	pe.IncProbeCount("probe1", 200)
+
+	if got := pe.ProbeCount.Count["probe1"]; got.Value != 2 || got.Time != 200 {
+		t.Errorf("Got count: %v\n Want: 2 at 200", got)
+	}
+	if got := pe.ProbeErrorCount.ErrorCount["probe1"]; got.Value != 1 || got.Time != 100 {
+		t.Errorf("Got error count: %v\n Want: 1 at 100", got)
+	}
+	if got := pe.ProbeResultCount.ResultCount["probe1"][ResultError].Value; got != 1 {
+		t.Errorf("Got error result count: %v\n Want: 1", got)
+	}
+	if got := pe.ProbeState.State["probe1"]["down"].Value; got != 1 {
+		t.Errorf("Got down state: %v\n Want: 1", got)
+	}
+}
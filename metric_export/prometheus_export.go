@@ -2,49 +2,84 @@ package metric_export
 
 import (
 	"flag"
-	"github.com/marpaia/graphite-golang"
+	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/samitpal/goProbe/modules"
+	"github.com/samitpal/goProbe/version"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type prometheusExport struct {
 	ProbeCount        *prometheus.CounterVec
 	ProbeErrorCount   *prometheus.CounterVec
 	ProbeTimeoutCount *prometheus.CounterVec
+	ProbeResultCount  *prometheus.CounterVec
+	ProbeState        *prometheus.GaugeVec
 	ProbeIsUp         *prometheus.GaugeVec
 	ProbeLatency      *prometheus.GaugeVec
 	ProbePayloadSize  *prometheus.GaugeVec
+	ProbeAttempts     *prometheus.GaugeVec
+	ProbeRetryCount   *prometheus.CounterVec
+	ProbeFlags        map[string]*prometheus.GaugeVec // keyed by flag.
+
+	BuildInfo                   *prometheus.GaugeVec
+	ConfigLastReloadSuccess     prometheus.Gauge
+	ConfigLastReloadSuccessTime prometheus.Gauge
+
+	registry  *prometheus.Registry // the exporter's own registry, (re)created by Prepare.
+	clean     bool                 // if set, no -1 sentinel values are used. See the clean_metrics flag.
+	probeOnly bool                 // if set, only the probe metrics are exposed.
+
+	lock      sync.RWMutex
+	lastRun   map[string]int64  // epoch time (seconds) of the last run of each probe, used as the Snapshot timestamp.
+	lastState map[string]string // the current state of each probe.
 }
 
 var (
 	labels                   = []string{"probe_name"}
 	prometheusProbeNameSpace = flag.String("prometheus_probe_name_space", "probe", "Prometheus name space of the probes. Valid with prometheus exposition type")
+	prometheusRuntimeMetrics = flag.Bool("prometheus_runtime_metrics", false, "Whether to also expose the go runtime and process metrics. Valid with prometheus exposition type")
 )
 
 func NewPrometheusExport() *prometheusExport {
-	return new(prometheusExport)
+	return &prometheusExport{clean: *cleanMetrics, lastRun: make(map[string]int64), lastState: make(map[string]string)}
+}
+
+// NewPrometheusProbeExport returns a prometheus exporter for the metrics of a single probe run, as served by the
+// /probe handler. It leaves out the goProbe wide metrics like the build info and the runtime metrics.
+func NewPrometheusProbeExport() *prometheusExport {
+	return &prometheusExport{clean: *cleanMetrics, probeOnly: true, lastRun: make(map[string]int64), lastState: make(map[string]string)}
 }
 
 // prometheusExport implements MetricExporter
 
+// Prepare sets up the metrics on a registry owned by the exporter rather than on the global prometheus registry.
+// Hence it is safe to call it more than once, each call starting from a fresh registry.
 func (p *prometheusExport) Prepare() {
+	p.registry = prometheus.NewRegistry()
+
 	p.ProbeIsUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: *prometheusProbeNameSpace,
 		Name:      "up",
-		Help:      "Indicates success/failure of the probe. Value of 1 is a success while 0 is a failure. Value of -1 could be because of probe timeout/error.",
+		Help:      "Indicates success/failure of the probe. Value of 1 is a success while 0 is a failure. Value of -1 could be because of probe timeout/error, unless clean metrics are enabled.",
 	}, labels)
 
 	p.ProbeLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: *prometheusProbeNameSpace,
 		Name:      "latency",
-		Help:      "The probe latency in milliseconds. Value of -1 could be because of probe timeout/error.",
+		Help:      "The probe latency in milliseconds. Value of -1 could be because of probe timeout/error, unless clean metrics are enabled.",
 	}, labels)
 
 	p.ProbePayloadSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: *prometheusProbeNameSpace,
 		Name:      "payload_size",
-		Help:      "The probe response payload size in bytes. Value of -1 could be because of probe timeout/error.",
+		Help:      "The probe response payload size in bytes. Value of -1 could be because of probe timeout/error, unless clean metrics are enabled.",
 	}, labels)
 
 	p.ProbeErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -59,23 +94,96 @@ func (p *prometheusExport) Prepare() {
 		Help:      "The probe timeout count.",
 	}, labels)
 
+	p.ProbeResultCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: *prometheusProbeNameSpace,
+		Name:      "result",
+		Help:      "The probe count by result. The result label is one of success, failure, error or timeout.",
+	}, []string{"probe_name", "result"})
+
+	p.ProbeState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: *prometheusProbeNameSpace,
+		Name:      "state",
+		Help:      "The debounced state of the probe. Value of 1 for the current state, 0 for the previous ones. The state label is one of unknown, up, down, unreachable or flapping.",
+	}, []string{"probe_name", "state"})
+
+	p.ProbeAttempts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: *prometheusProbeNameSpace,
+		Name:      "attempts",
+		Help:      "The number of attempts of the last probe run, more than 1 if it was retried.",
+	}, labels)
+
+	p.ProbeRetryCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: *prometheusProbeNameSpace,
+		Name:      "retry_count",
+		Help:      "The number of retried probe attempts.",
+	}, labels)
+
+	p.ProbeFlags = make(map[string]*prometheus.GaugeVec)
+	for _, f := range ProbeFlags {
+		p.ProbeFlags[f.Name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: *prometheusProbeNameSpace,
+			Name:      f.Name,
+			Help:      f.Help + " Value of 1 if so, 0 otherwise.",
+		}, labels)
+	}
+
 	p.ProbeCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: *prometheusProbeNameSpace,
 		Name:      "count",
 		Help:      "Total Probe count.",
 	}, labels)
 
-	prometheus.MustRegister(p.ProbeCount)
-	prometheus.MustRegister(p.ProbeErrorCount)
-	prometheus.MustRegister(p.ProbeTimeoutCount)
-	prometheus.MustRegister(p.ProbeLatency)
-	prometheus.MustRegister(p.ProbeIsUp)
-	prometheus.MustRegister(p.ProbePayloadSize)
+	p.BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "goprobe",
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by version, revision, branch and goversion from which goProbe was built.",
+	}, []string{"version", "revision", "branch", "goversion"})
+	p.BuildInfo.WithLabelValues(version.Version, version.Revision, version.Branch, version.GoVersion).Set(1)
+
+	p.ConfigLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "goprobe",
+		Name:      "config_last_reload_success",
+		Help:      "Whether the last probe config load was successful.",
+	})
+
+	p.ConfigLastReloadSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "goprobe",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful probe config load.",
+	})
+
+	p.registry.MustRegister(p.ProbeCount)
+	p.registry.MustRegister(p.ProbeErrorCount)
+	p.registry.MustRegister(p.ProbeTimeoutCount)
+	p.registry.MustRegister(p.ProbeResultCount)
+	p.registry.MustRegister(p.ProbeState)
+	p.registry.MustRegister(p.ProbeLatency)
+	p.registry.MustRegister(p.ProbeIsUp)
+	p.registry.MustRegister(p.ProbePayloadSize)
+	p.registry.MustRegister(p.ProbeAttempts)
+	p.registry.MustRegister(p.ProbeRetryCount)
+	for _, f := range ProbeFlags {
+		p.registry.MustRegister(p.ProbeFlags[f.Name])
+	}
+	if p.probeOnly {
+		return
+	}
+	p.registry.MustRegister(p.BuildInfo)
+	p.registry.MustRegister(p.ConfigLastReloadSuccess)
+	p.registry.MustRegister(p.ConfigLastReloadSuccessTime)
+
+	if *prometheusRuntimeMetrics {
+		p.registry.MustRegister(collectors.NewGoCollector())
+		p.registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
 }
 
 // IncProbeCount increments the probe count of a given probe.
 func (p *prometheusExport) IncProbeCount(probeName string, t int64) {
 	p.ProbeCount.WithLabelValues(probeName).Inc()
+	p.lock.Lock()
+	p.lastRun[probeName] = t
+	p.lock.Unlock()
 }
 
 // IncErrorCount increments the error count of a given probe.
@@ -88,6 +196,11 @@ func (p *prometheusExport) IncProbeTimeoutCount(probeName string, t int64) {
 	p.ProbeTimeoutCount.WithLabelValues(probeName).Inc()
 }
 
+// IncProbeResultCount increments the count of the given result of a given probe.
+func (p *prometheusExport) IncProbeResultCount(probeName string, result string, t int64) {
+	p.ProbeResultCount.WithLabelValues(probeName, result).Inc()
+}
+
 // SetFieldValues function sets the field values during normal times, e.g set the ‘up’ variable to 1 or 0.
 func (p *prometheusExport) SetFieldValues(probeName string, pd *modules.ProbeData, t int64) {
 	p.ProbeIsUp.WithLabelValues(probeName).Set(*pd.IsUp)
@@ -99,18 +212,192 @@ func (p *prometheusExport) SetFieldValues(probeName string, pd *modules.ProbeDat
 
 // SetFieldValuesUnexpected function sets field values during unexpected situations, e.g probe errors/timeouts. For instance
 // you might want to set the ‘up’ variable for a probe which timed out to -1 instead of a 0 or 1.
+// In clean mode ‘up’ is set to 0 while the latency and payload size series are removed, so that they go stale.
 func (p *prometheusExport) SetFieldValuesUnexpected(probeName string, t int64) {
+	if p.clean {
+		p.ProbeIsUp.WithLabelValues(probeName).Set(0)
+		p.ProbeLatency.DeleteLabelValues(probeName)
+		p.ProbePayloadSize.DeleteLabelValues(probeName)
+		return
+	}
 	p.ProbeIsUp.WithLabelValues(probeName).Set(-1)
 	p.ProbeLatency.WithLabelValues(probeName).Set(-1)
 	p.ProbePayloadSize.WithLabelValues(probeName).Set(-1)
 }
 
-//MetricHttpHandler registers a http handler to expose the metrics
-func (p prometheusExport) MetricHttpHandler() http.Handler {
-	return prometheus.Handler()
+// SetProbeState sets the gauge of the current state of a given probe to 1, and the one of its previous state to 0.
+func (p *prometheusExport) SetProbeState(probeName string, state string, t int64) {
+	p.lock.Lock()
+	prev, ok := p.lastState[probeName]
+	p.lastState[probeName] = state
+	p.lock.Unlock()
+	if ok && prev != state {
+		p.ProbeState.WithLabelValues(probeName, prev).Set(0)
+	}
+	p.ProbeState.WithLabelValues(probeName, state).Set(1)
 }
 
-// This is not used, but needs to be defined to satisfy the interface.
-func (p prometheusExport) RetGraphiteMetrics(pn string) []graphite.Metric {
-	return []graphite.Metric{}
+// SetProbeAttempts sets the attempts gauge of a given probe and adds the retried attempts to its retry counter.
+func (p *prometheusExport) SetProbeAttempts(probeName string, attempts int, t int64) {
+	p.ProbeAttempts.WithLabelValues(probeName).Set(float64(attempts))
+	p.ProbeRetryCount.WithLabelValues(probeName).Add(float64(attempts - 1))
+}
+
+// SetProbeFlag sets the gauge of the given flag of a given probe to 1 if set, 0 otherwise.
+func (p *prometheusExport) SetProbeFlag(probeName string, flag string, set bool, t int64) {
+	g, ok := p.ProbeFlags[flag]
+	if !ok {
+		return
+	}
+	if set {
+		g.WithLabelValues(probeName).Set(1)
+	} else {
+		g.WithLabelValues(probeName).Set(0)
+	}
+}
+
+// SetConfigReloadStatus records the outcome of the last probe config load.
+func (p *prometheusExport) SetConfigReloadStatus(success bool, t int64) {
+	if success {
+		p.ConfigLastReloadSuccess.Set(1)
+		p.ConfigLastReloadSuccessTime.Set(float64(t))
+	} else {
+		p.ConfigLastReloadSuccess.Set(0)
+	}
+}
+
+// AddSelfMetric registers the given metric about goProbe itself, under the goprobe namespace.
+func (p *prometheusExport) AddSelfMetric(sm SelfMetric) {
+	opts := prometheus.Opts{Namespace: "goprobe", Name: sm.Name, Help: sm.Help, ConstLabels: sm.Labels}
+	var c prometheus.Collector
+	if sm.Counter {
+		c = prometheus.NewCounterFunc(prometheus.CounterOpts(opts), sm.Value)
+	} else {
+		c = prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts), sm.Value)
+	}
+	if err := p.registry.Register(c); err != nil {
+		glog.Errorf("Error registering metric %s: %v", sm.Name, err)
+	}
+}
+
+// MetricHttpHandler returns a http handler which exposes the metrics of the exporter's registry. The OpenMetrics
+// format is served to the clients asking for it via the Accept header.
+func (p *prometheusExport) MetricHttpHandler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Snapshot returns the current metrics of the given probe.
+func (p *prometheusExport) Snapshot(probeName string) []Sample {
+	p.lock.RLock()
+	t := p.lastRun[probeName]
+	p.lock.RUnlock()
+
+	var samples []Sample
+	samples = append(samples, collectSamples(p.ProbeCount, "count", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeErrorCount, "error_count", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeTimeoutCount, "timeout_count", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeResultCount, "result", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeState, "state", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeIsUp, "up", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeLatency, "latency", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbePayloadSize, "payload_size", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeAttempts, "attempts", probeName, t)...)
+	samples = append(samples, collectSamples(p.ProbeRetryCount, "retry_count", probeName, t)...)
+	for _, f := range ProbeFlags {
+		samples = append(samples, collectSamples(p.ProbeFlags[f.Name], f.Name, probeName, t)...)
+	}
+	return samples
+}
+
+// Restore sets the metrics of the given probe from the given samples. The counters are increased by the sample
+// values while the gauges are set to them.
+func (p *prometheusExport) Restore(probeName string, samples []Sample) {
+	for _, s := range samples {
+		switch s.Name {
+		case "count", "error_count", "timeout_count", "result", "retry_count":
+			if s.Value <= 0 {
+				continue // a counter can not go down.
+			}
+			switch s.Name {
+			case "count":
+				p.ProbeCount.WithLabelValues(probeName).Add(s.Value)
+			case "error_count":
+				p.ProbeErrorCount.WithLabelValues(probeName).Add(s.Value)
+			case "timeout_count":
+				p.ProbeTimeoutCount.WithLabelValues(probeName).Add(s.Value)
+			case "result":
+				p.ProbeResultCount.WithLabelValues(probeName, s.Labels["result"]).Add(s.Value)
+			case "retry_count":
+				p.ProbeRetryCount.WithLabelValues(probeName).Add(s.Value)
+			}
+		case "state":
+			p.ProbeState.WithLabelValues(probeName, s.Labels["state"]).Set(s.Value)
+			if s.Value == 1 {
+				p.lock.Lock()
+				p.lastState[probeName] = s.Labels["state"]
+				p.lock.Unlock()
+			}
+		case "up":
+			p.ProbeIsUp.WithLabelValues(probeName).Set(s.Value)
+		case "latency":
+			p.ProbeLatency.WithLabelValues(probeName).Set(s.Value)
+		case "payload_size":
+			p.ProbePayloadSize.WithLabelValues(probeName).Set(s.Value)
+		case "attempts":
+			p.ProbeAttempts.WithLabelValues(probeName).Set(s.Value)
+		}
+		p.lock.Lock()
+		if s.Timestamp > p.lastRun[probeName] {
+			p.lastRun[probeName] = s.Timestamp
+		}
+		p.lock.Unlock()
+	}
+}
+
+// collectSamples returns the values of the metrics of the given collector which are labeled with the given probe name,
+// sorted by their labels since a collector does not collect its metrics in any given order.
+func collectSamples(c prometheus.Collector, name string, probeName string, t int64) []Sample {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var samples []Sample
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err != nil {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, lp := range d.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["probe_name"] != probeName {
+			continue
+		}
+		s := Sample{Name: name, Timestamp: t, Labels: labels}
+		if d.Counter != nil {
+			s.Value = d.Counter.GetValue()
+		} else if d.Gauge != nil {
+			s.Value = d.Gauge.GetValue()
+		}
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool { return labelKey(samples[i].Labels) < labelKey(samples[j].Labels) })
+	return samples
+}
+
+// labelKey returns the given labels as a string, in the order of the label names.
+func labelKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, n := range names {
+		b.WriteString(n + "=" + labels[n] + ",")
+	}
+	return b.String()
 }
@@ -0,0 +1,171 @@
+package metric_export
+
+import (
+	"github.com/samitpal/goProbe/modules"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusPrepareTwice(t *testing.T) {
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	// This used to panic since the metrics were registered with the global registry.
+	pe.Prepare()
+}
+
+func TestPrometheusMetricHttpHandler(t *testing.T) {
+	up := float64(1)
+	lt := float64(123)
+	st := int64(567)
+	et := int64(890)
+	pd := modules.ProbeData{IsUp: &up, Latency: &lt, StartTime: &st, EndTime: &et}
+
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.IncProbeCount("probe1", time.Now().Unix())
+	pe.SetFieldValues("probe1", &pd, time.Now().Unix())
+	pe.SetConfigReloadStatus(true, 1234)
+
+	ts := httptest.NewServer(pe.MetricHttpHandler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text") {
+		t.Errorf("Got content type: %v\n Want: application/openmetrics-text", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		`probe_up{probe_name="probe1"} 1`,
+		`probe_latency{probe_name="probe1"} 123`,
+		`goprobe_build_info{`,
+		`goprobe_config_last_reload_success 1`,
+		`goprobe_config_last_reload_success_timestamp_seconds 1234`,
+		"# EOF",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metric output is missing %q. Got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), "go_goroutines") {
+		t.Errorf("Go runtime metrics should not be exposed by default. Got:\n%s", body)
+	}
+}
+
+func TestPrometheusSnapshot(t *testing.T) {
+	up := float64(0)
+	lt := float64(55)
+	pd := modules.ProbeData{IsUp: &up, Latency: &lt}
+
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.IncProbeCount("probe1", 100)
+	pe.IncProbeResultCount("probe1", ResultTimeout, 100)
+	pe.IncProbeCount("probe1", 160)
+	pe.IncProbeResultCount("probe1", ResultFailure, 160)
+	pe.SetFieldValues("probe1", &pd, 160)
+	pe.IncProbeCount("probe2", 170)
+
+	want := []Sample{
+		{Name: "count", Value: 2, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+		{Name: "result", Value: 1, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1", "result": ResultFailure}},
+		{Name: "result", Value: 1, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1", "result": ResultTimeout}},
+		{Name: "up", Value: 0, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+		{Name: "latency", Value: 55, Timestamp: 160, Labels: map[string]string{"probe_name": "probe1"}},
+	}
+	for i := 0; i < 10; i++ {
+		if got := pe.Snapshot("probe1"); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Got: %v\n Want: %v", got, want)
+		}
+	}
+}
+
+func TestPrometheusSetProbeState(t *testing.T) {
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.IncProbeCount("probe1", 100)
+	pe.SetProbeState("probe1", "up", 100)
+	pe.SetProbeState("probe1", "flapping", 100)
+
+	want := map[string]float64{"up": 0, "flapping": 1}
+	got := make(map[string]float64)
+	for _, s := range pe.Snapshot("probe1") {
+		if s.Name == "state" {
+			got[s.Labels["state"]] = s.Value
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestPrometheusSetProbeFlag(t *testing.T) {
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.SetProbeFlag("probe1", FlagSilenced, true, 100)
+	pe.SetProbeFlag("probe1", FlagPaused, false, 100)
+
+	want := map[string]float64{FlagPaused: 0, FlagSilenced: 1}
+	got := make(map[string]float64)
+	for _, s := range pe.Snapshot("probe1") {
+		got[s.Name] = s.Value
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestPrometheusSetProbeAttempts(t *testing.T) {
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.SetProbeAttempts("probe1", 3, 100)
+	pe.SetProbeAttempts("probe1", 1, 160)
+
+	want := map[string]float64{"attempts": 1, "retry_count": 2}
+	got := make(map[string]float64)
+	for _, s := range pe.Snapshot("probe1") {
+		got[s.Name] = s.Value
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestPrometheusRestore(t *testing.T) {
+	pe := NewPrometheusExport()
+	pe.Prepare()
+	pe.IncProbeCount("probe1", 100)
+	pe.IncProbeResultCount("probe1", ResultTimeout, 100)
+	pe.SetFieldValuesUnexpected("probe1", 100)
+	pe.SetProbeState("probe1", "down", 100)
+	saved := pe.Snapshot("probe1")
+
+	// restored after a restart, then one more run.
+	pe = NewPrometheusExport()
+	pe.Prepare()
+	pe.Restore("probe1", saved)
+	pe.IncProbeCount("probe1", 200)
+	pe.SetProbeState("probe1", "up", 200)
+
+	got := make(map[string]float64)
+	for _, s := range pe.Snapshot("probe1") {
+		got[s.Name+s.Labels["result"]+s.Labels["state"]] = s.Value
+	}
+	want := map[string]float64{"count": 2, "resulttimeout": 1, "up": -1, "latency": -1, "payload_size": -1, "statedown": 0, "stateup": 1}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: Got: %v\n Want: %v", k, got[k], v)
+		}
+	}
+}
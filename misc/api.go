@@ -0,0 +1,418 @@
+package misc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samitpal/goProbe/conf"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/modules"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunView is the json view of the last run of a probe.
+type RunView struct {
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Result      string    `json:"result"`                 // one of success, failure, error or timeout.
+	Latency     *float64  `json:"latency,omitempty"`      // in milli seconds.
+	PayloadSize *float64  `json:"payload_size,omitempty"` // in bytes.
+	HttpStatus  string    `json:"http_status,omitempty"`  // for the http module, e.g 200 OK.
+
+	// Only set for a single probe.
+	HttpHeaders http.Header `json:"http_headers,omitempty"`
+	Body        string      `json:"body,omitempty"` // the start of the response payload, see maxBody.
+}
+
+// maxBody is the max size of the response payload in a RunView.
+const maxBody = 4096
+
+// ProbeView is the json view of a probe along with its status, as served by the /api/v1/probes handlers.
+type ProbeView struct {
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	RunInterval     string            `json:"run_interval"`      // a duration, e.g 1m0s, or a cron expression.
+	RunIntervalSecs int               `json:"run_interval_secs"` // the whole seconds of the interval, 0 for a cron expression.
+	Timeout         string            `json:"timeout"`
+	TimeoutSecs     int               `json:"timeout_secs"`
+	State           string            `json:"state"`
+	StateSince      *time.Time        `json:"state_since,omitempty"`
+	LastRun         *RunView          `json:"last_run,omitempty"`
+	LastFailure     *time.Time        `json:"last_failure,omitempty"`
+	ProbeControl
+
+	// Only set for a single probe.
+	Config  json.RawMessage `json:"config,omitempty"`
+	History []HistoryEntry  `json:"history,omitempty"`
+}
+
+// ConfigView is the json view of the config of a probe, as served by /api/v1/config.
+type ConfigView struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config"`
+}
+
+// API serves the json api built from the live probe set and their status.
+type API struct {
+	probes []modules.Prober
+	byName map[string]modules.Prober
+	types  map[string]string // probe types by probe name.
+	ps     *ProbesStatus
+	sched  Scheduler
+
+	testEnabled bool // see EnableTest.
+}
+
+// NewAPI returns the api of the given probes. The probes are run on demand and controlled by the given scheduler.
+func NewAPI(probes []modules.Prober, ps *ProbesStatus, sched Scheduler) *API {
+	a := &API{probes: probes, byName: make(map[string]modules.Prober), types: make(map[string]string), ps: ps, sched: sched}
+	for _, p := range probes {
+		a.byName[*p.Name()] = p
+		a.types[*p.Name()] = conf.ProbeType(p)
+	}
+	return a
+}
+
+// probeView returns the view of the given probe. The config and history are only added if detailed is set.
+func (a *API) probeView(p modules.Prober, detailed bool) ProbeView {
+	pn := *p.Name()
+	v := ProbeView{
+		Name:            pn,
+		Type:            a.types[pn],
+		Labels:          p.Options().ProbeLabels,
+		RunInterval:     p.RunInterval().String(),
+		RunIntervalSecs: int(p.RunInterval().Duration / time.Second),
+		Timeout:         p.Timeout().String(),
+		TimeoutSecs:     int(p.Timeout().Duration / time.Second),
+		State:           StateUnknown,
+		ProbeControl:    a.sched.Control(pn),
+	}
+	if status := a.ps.ReadProbeStatus(pn); status != nil {
+		v.State = status.State
+		since := time.Unix(0, status.StateSince)
+		v.StateSince = &since
+		v.LastRun = runView(status, detailed)
+		if status.LastFailure != 0 {
+			lf := time.Unix(0, status.LastFailure)
+			v.LastFailure = &lf
+		}
+	}
+	if detailed {
+		v.Config = rawConfig(p)
+		v.History = a.ps.ReadHistory(pn)
+	}
+	return v
+}
+
+// runView returns the view of the run of the given status. The http headers and the payload are only added if
+// detailed is set.
+func runView(status *ProbeStatus, detailed bool) *RunView {
+	v := &RunView{StartTime: time.Unix(0, status.ProbeStartTime), EndTime: time.Unix(0, status.ProbeEndTime)}
+	switch {
+	case status.ProbeError:
+		v.Result = metric_export.ResultError
+	case status.ProbeTimeout:
+		v.Result = metric_export.ResultTimeout
+	case status.ProbeResp != nil:
+		v.Result = metric_export.ResultFailure
+		if status.ProbeResp.IsUp != nil && *status.ProbeResp.IsUp == 1 {
+			v.Result = metric_export.ResultSuccess
+		}
+		v.Latency = status.ProbeResp.Latency
+		v.PayloadSize = status.ProbeResp.PayloadSize
+		if status.ProbeResp.Http != nil && status.ProbeResp.Http.Status != nil {
+			v.HttpStatus = *status.ProbeResp.Http.Status
+		}
+		if !detailed {
+			break
+		}
+		if status.ProbeResp.Http != nil && status.ProbeResp.Http.Headers != nil {
+			v.HttpHeaders = *status.ProbeResp.Http.Headers
+		}
+		if status.ProbeResp.Payload != nil {
+			body := *status.ProbeResp.Payload
+			if len(body) > maxBody {
+				body = body[:maxBody]
+			}
+			v.Body = string(body)
+		}
+	}
+	return v
+}
+
+// rawConfig returns the config of the given probe as json. It is a json string if the module does not return json.
+func rawConfig(p modules.Prober) json.RawMessage {
+	c := p.RetConfig()
+	if json.Valid([]byte(c)) {
+		return json.RawMessage(c)
+	}
+	s, _ := json.Marshal(c)
+	return s
+}
+
+// HandleProbes serves /api/v1/probes, the list of probes along with their status, as well as
+// /api/v1/probes/{name}, /api/v1/probes/{name}/history and the POST /api/v1/probes/{name}/{action} requests, see
+// postProbe. The list can be filtered and sorted by the following query parameters.
+//
+//	state: the debounced state, e.g down.
+//	type: the probe type, e.g http.
+//	label: a probe label as key=value, e.g env=prod. Can be given more than once.
+//	sort: name (the default, in config order), latency (slowest first) or last_failure (most recent first).
+func (a *API) HandleProbes(w http.ResponseWriter, r *http.Request) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/probes"), "/")
+	pn, sub := p, ""
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		pn, sub = p[:i], p[i+1:]
+	}
+	if probeActions[sub] {
+		if !allowMethods(w, r, "POST") || !requireJSON(w, r) {
+			return
+		}
+		a.postProbe(w, r, pn, sub)
+		return
+	}
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+	switch {
+	case p == "":
+		a.writeProbes(w, r)
+	case sub == "":
+		a.writeProbe(w, pn)
+	case sub == "history":
+		if _, ok := a.byName[pn]; !ok {
+			writeJSONError(w, fmt.Sprintf("Unknown probe '%s'", pn), http.StatusNotFound)
+			return
+		}
+		entries := a.ps.ReadHistory(pn)
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		writeJSON(w, map[string]interface{}{"probe_name": pn, "history": entries})
+	default:
+		writeJSONError(w, fmt.Sprintf("Unknown path '%s'", r.URL.Path), http.StatusNotFound)
+	}
+}
+
+// HandleHistory serves /api/probes/{name}/history, the former path of /api/v1/probes/{name}/history.
+func (a *API) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/probes/")
+	if !strings.HasSuffix(p, "/history") {
+		writeJSONError(w, fmt.Sprintf("Unknown path '%s'", r.URL.Path), http.StatusNotFound)
+		return
+	}
+	r = r.Clone(r.Context())
+	r.URL.Path = "/api/v1/probes/" + p
+	a.HandleProbes(w, r)
+}
+
+// probeFilter selects the probes to list.
+type probeFilter struct {
+	state  string
+	typ    string
+	labels map[string]string
+}
+
+func newProbeFilter(q url.Values) (probeFilter, error) {
+	f := probeFilter{state: q.Get("state"), typ: q.Get("type"), labels: make(map[string]string)}
+	for _, l := range q["label"] {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return f, fmt.Errorf("Invalid label filter '%s', expecting key=value", l)
+		}
+		f.labels[kv[0]] = kv[1]
+	}
+	return f, nil
+}
+
+func (f probeFilter) match(v ProbeView) bool {
+	if f.state != "" && v.State != f.state {
+		return false
+	}
+	if f.typ != "" && v.Type != f.typ {
+		return false
+	}
+	for k, val := range f.labels {
+		if lv, ok := v.Labels[k]; !ok || lv != val {
+			return false
+		}
+	}
+	return true
+}
+
+// sortProbes sorts the given views as set by the sort query parameter.
+func sortProbes(views []ProbeView, by string) error {
+	switch by {
+	case "", "name":
+		// config order.
+	case "latency":
+		latency := func(v ProbeView) float64 {
+			if v.LastRun == nil || v.LastRun.Latency == nil {
+				return -1 // no latency, listed last.
+			}
+			return *v.LastRun.Latency
+		}
+		sort.SliceStable(views, func(i, j int) bool { return latency(views[i]) > latency(views[j]) })
+	case "last_failure":
+		lastFailure := func(v ProbeView) int64 {
+			if v.LastFailure == nil {
+				return 0 // never failed, listed last.
+			}
+			return v.LastFailure.UnixNano()
+		}
+		sort.SliceStable(views, func(i, j int) bool { return lastFailure(views[i]) > lastFailure(views[j]) })
+	default:
+		return fmt.Errorf("Invalid sort '%s', expecting name, latency or last_failure", by)
+	}
+	return nil
+}
+
+// summary counts the probes of each state, along with the probes whose last run error'ed out or timed out and the
+// paused, silenced and in maintenance ones.
+func summary(views []ProbeView) map[string]int {
+	s := map[string]int{"total": len(views), metric_export.ResultError: 0, metric_export.ResultTimeout: 0, "paused": 0, "silenced": 0, "in_maintenance": 0}
+	for _, st := range States {
+		s[st] = 0
+	}
+	for _, v := range views {
+		s[v.State]++
+		if v.LastRun != nil && (v.LastRun.Result == metric_export.ResultError || v.LastRun.Result == metric_export.ResultTimeout) {
+			s[v.LastRun.Result]++
+		}
+		if v.Paused {
+			s["paused"]++
+		}
+		if v.SilencedUntil != nil {
+			s["silenced"]++
+		}
+		if v.Maintenance != "" {
+			s["in_maintenance"]++
+		}
+	}
+	return s
+}
+
+// writeProbes writes the filtered and sorted list of probes, along with a summary of all the probes.
+func (a *API) writeProbes(w http.ResponseWriter, r *http.Request) {
+	f, err := newProbeFilter(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	all := make([]ProbeView, 0, len(a.probes))
+	views := make([]ProbeView, 0, len(a.probes))
+	for _, p := range a.probes {
+		v := a.probeView(p, false)
+		all = append(all, v)
+		if f.match(v) {
+			views = append(views, v)
+		}
+	}
+	if err = sortProbes(views, r.URL.Query().Get("sort")); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"summary": summary(all), "probes": views})
+}
+
+func (a *API) writeProbe(w http.ResponseWriter, pn string) {
+	p, ok := a.byName[pn]
+	if !ok {
+		writeJSONError(w, fmt.Sprintf("Unknown probe '%s'", pn), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.probeView(p, true))
+}
+
+// HandleStatus serves the json form of the /status page, i.e the list of probes, or a single probe when the
+// showparams query parameter is single_probe.
+func (a *API) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("showparams") == "single_probe" {
+		a.writeProbe(w, r.URL.Query().Get("probe_name"))
+		return
+	}
+	a.writeProbes(w, r)
+}
+
+// HandleConfig serves /api/v1/config, the config of the live probes.
+func (a *API) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+	views := make([]ConfigView, 0, len(a.probes))
+	for _, p := range a.probes {
+		views = append(views, ConfigView{Name: *p.Name(), Type: a.types[*p.Name()], Config: rawConfig(p)})
+	}
+	writeJSON(w, map[string]interface{}{"probes": views})
+}
+
+// Negotiate returns a handler serving the json handler to the clients asking for json via the Accept header,
+// and the html handler to the others.
+func Negotiate(htmlHandler, jsonHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if acceptsJSON(r) {
+			jsonHandler.ServeHTTP(w, r)
+			return
+		}
+		htmlHandler.ServeHTTP(w, r)
+	})
+}
+
+// acceptsJSON tells whether the Accept header of the request lists application/json.
+func acceptsJSON(r *http.Request) bool {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err == nil && mt == "application/json" && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowMethods tells whether the request method is one of the given ones. If not, it writes a method not allowed
+// error.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// requireJSON tells whether the request is sent as json, i.e with a Content-Type: application/json header, the body
+// being optional. If not, it writes an unsupported media type error. The requests changing the probes need to be
+// sent as json, which a web page can not do cross-site, unlike a form.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "application/json" {
+		return true
+	}
+	writeJSONError(w, "Expecting a request with a Content-Type: application/json header", http.StatusUnsupportedMediaType)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	dst, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(dst)
+}
+
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	dst, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(dst)
+}
@@ -0,0 +1,183 @@
+package misc
+
+import (
+	"encoding/json"
+	"github.com/samitpal/goProbe/conf"
+	"github.com/samitpal/goProbe/modules"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jsonRequest returns a request sent as json, as the api POST requests need to be.
+func jsonRequest(method, path, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func testAPI(t *testing.T) (*API, *ProbesStatus) {
+	probes, err := conf.SetupConfig([]byte(`[
+		{"probe_type": "http", "probe_config": {"probe_name": "web", "probe_url": "http://example.com", "probe_labels": {"env": "prod"}}},
+		{"probe_type": "ping_port", "probe_config": {"probe_name": "ssh", "probe_host_name": "example.com", "probe_host_port": 22}}
+	]`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ps := NewProbesStatus(conf.GetProbeNames(probes))
+	up, latency, status := float64(1), float64(12), "200 OK"
+	st := int64(1450000000000000000)
+	ps.WriteProbeStatus("web", &modules.ProbeData{IsUp: &up, Latency: &latency, Http: &modules.HttpFields{Status: &status}}, st, st)
+	ps.AddHistory("web", HistoryEntry{StartTime: st, Result: "success", Latency: &latency, StatusCode: 200})
+	return NewAPI(probes, ps, &fakeScheduler{controls: make(map[string]ProbeControl)}), ps
+}
+
+func TestHandleProbes(t *testing.T) {
+	a, _ := testAPI(t)
+
+	w := httptest.NewRecorder()
+	a.HandleProbes(w, httptest.NewRequest("GET", "/api/v1/probes", nil))
+	var list struct {
+		Probes []ProbeView `json:"probes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(list.Probes) != 2 {
+		t.Fatalf("Got: %+v\n Want 2 probes", list)
+	}
+	web, ssh := list.Probes[0], list.Probes[1]
+	if web.Name != "web" || web.Type != "http" || web.Labels["env"] != "prod" || web.State != StateUp ||
+		web.LastRun == nil || web.LastRun.Result != "success" || web.LastRun.HttpStatus != "200 OK" || web.Config != nil {
+		t.Errorf("Got: %+v", web)
+	}
+	if ssh.Name != "ssh" || ssh.Type != "ping_port" || ssh.State != StateUnknown || ssh.LastRun != nil {
+		t.Errorf("Got: %+v", ssh)
+	}
+
+	w = httptest.NewRecorder()
+	a.HandleProbes(w, httptest.NewRequest("GET", "/api/v1/probes/web", nil))
+	var p ProbeView
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var c map[string]interface{}
+	if err := json.Unmarshal(p.Config, &c); err != nil || c["probe_url"] != "http://example.com" || len(p.History) != 1 {
+		t.Errorf("Got: %+v, %v", p, err)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/api/v1/probes/web/history", http.StatusOK},
+		{"GET", "/api/v1/probes/unknown", http.StatusNotFound},
+		{"GET", "/api/v1/probes/unknown/history", http.StatusNotFound},
+		{"GET", "/api/v1/probes/web/invalid", http.StatusNotFound},
+		{"DELETE", "/api/v1/probes/web", http.StatusMethodNotAllowed},
+	} {
+		w = httptest.NewRecorder()
+		a.HandleProbes(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: Got: %d, %s\n Want: %d", tt.method, tt.path, w.Code, w.Header().Get("Content-Type"), tt.code)
+		}
+	}
+}
+
+func TestAPIHandleConfig(t *testing.T) {
+	a, _ := testAPI(t)
+	w := httptest.NewRecorder()
+	a.HandleConfig(w, httptest.NewRequest("GET", "/api/v1/config", nil))
+	var resp struct {
+		Probes []struct {
+			Name   string                 `json:"name"`
+			Type   string                 `json:"type"`
+			Config map[string]interface{} `json:"config"`
+		} `json:"probes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(resp.Probes) != 2 || resp.Probes[1].Type != "ping_port" || resp.Probes[1].Config["probe_host_port"] != float64(22) {
+		t.Errorf("Got: %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	a.HandleConfig(w, httptest.NewRequest("POST", "/api/v1/config", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Got: %d\n Want: %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	html := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("html")) })
+	json := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("json")) })
+	h := Negotiate(html, json)
+	for accept, want := range map[string]string{
+		"":                                  "html",
+		"text/html,application/xhtml+xml":   "html",
+		"application/json":                  "json",
+		"text/html;q=0.9, application/json": "json",
+		"application/json;q=0":              "html",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/status", nil)
+		r.Header.Set("Accept", accept)
+		h.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("Accept %q: Got: %s\n Want: %s", accept, w.Body.String(), want)
+		}
+	}
+}
+
+func TestHandleProbesFilters(t *testing.T) {
+	a, ps := testAPI(t)
+	down, latency := float64(0), float64(30)
+	st := int64(1450000060000000000)
+	ps.WriteProbeStatus("ssh", &modules.ProbeData{IsUp: &down, Latency: &latency}, st, st)
+
+	tests := []struct {
+		query string
+		want  []string // the probe names.
+	}{
+		{"", []string{"web", "ssh"}},
+		{"state=down", []string{"ssh"}},
+		{"type=http", []string{"web"}},
+		{"label=env%3Dprod", []string{"web"}},
+		{"label=env%3Ddev", []string{}},
+		{"sort=latency", []string{"ssh", "web"}},
+		{"sort=last_failure", []string{"ssh", "web"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.HandleProbes(w, httptest.NewRequest("GET", "/api/v1/probes?"+tt.query, nil))
+		var resp struct {
+			Summary map[string]int `json:"summary"`
+			Probes  []ProbeView    `json:"probes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: Error: %v", tt.query, err)
+		}
+		var got []string
+		for _, p := range resp.Probes {
+			got = append(got, p.Name)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("%s: Got: %v\n Want: %v", tt.query, got, tt.want)
+		}
+		// the summary is of all the probes.
+		if resp.Summary["total"] != 2 || resp.Summary[StateUp] != 1 || resp.Summary[StateDown] != 1 || resp.Summary["error"] != 0 {
+			t.Errorf("%s: Got summary: %v", tt.query, resp.Summary)
+		}
+	}
+
+	for _, q := range []string{"label=env", "sort=invalid"} {
+		w := httptest.NewRecorder()
+		a.HandleProbes(w, httptest.NewRequest("GET", "/api/v1/probes?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: Got: %d\n Want: %d", q, w.Code, http.StatusBadRequest)
+		}
+	}
+}
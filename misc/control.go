@@ -0,0 +1,105 @@
+package misc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ProbeControl is the runtime control of a probe, i.e whether it is paused or silenced as set via the api, along with
+// the maintenance window it is in as set in the config.
+type ProbeControl struct {
+	Paused        bool       `json:"paused,omitempty"`         // the probe is not run on schedule.
+	SilencedUntil *time.Time `json:"silenced_until,omitempty"` // the probe state changes are not notified till then.
+	Maintenance   string     `json:"maintenance,omitempty"`    // the name of the maintenance window the probe is in.
+}
+
+// Controller pauses and silences the probes at runtime.
+type Controller interface {
+	// Control returns the runtime control of the given probe. An expired silence is left out.
+	Control(pn string) ProbeControl
+
+	// Pause pauses or resumes the given probe.
+	Pause(pn string, paused bool)
+
+	// Silence silences the given probe till the given time. A zero time unsilences it.
+	Silence(pn string, until time.Time)
+}
+
+// Scheduler runs the probes on demand and controls them.
+type Scheduler interface {
+	Runner
+	Controller
+}
+
+// maxActionBody is the max size of the json body of the POST /api/v1/probes/{name}/{action} requests.
+const maxActionBody = 4096
+
+// probeActions lists the actions of the POST /api/v1/probes/{name}/{action} requests.
+var probeActions = map[string]bool{"run": true, "pause": true, "resume": true, "silence": true, "unsilence": true}
+
+// postProbe serves POST /api/v1/probes/{name}/{action}. The run action runs the probe out of schedule and returns
+// the outcome. The other ones return the probe, once paused, resumed, silenced or unsilenced. The silence action
+// takes a json body, see silenceUntil.
+func (a *API) postProbe(w http.ResponseWriter, r *http.Request, pn, action string) {
+	p, ok := a.byName[pn]
+	if !ok {
+		writeJSONError(w, fmt.Sprintf("Unknown probe '%s'", pn), http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "run":
+		writeRunResult(w, a.sched.RunProbe(p))
+		return
+	case "pause":
+		a.sched.Pause(pn, true)
+	case "resume":
+		a.sched.Pause(pn, false)
+	case "silence":
+		until, err := silenceUntil(r, time.Now())
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.sched.Silence(pn, until)
+	case "unsilence":
+		a.sched.Silence(pn, time.Time{})
+	}
+	writeJSON(w, a.probeView(p, false))
+}
+
+// silenceUntil returns the end of the silence asked for by the json body of the request, either as a time with the
+// until field, e.g {"until": "2016-01-02T15:04:05Z"}, or as a duration with the for field, e.g {"for": "2h"}.
+func silenceUntil(r *http.Request, now time.Time) (time.Time, error) {
+	var body struct {
+		Until string `json:"until"`
+		For   string `json:"for"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxActionBody)).Decode(&body); err != nil && err != io.EOF {
+		return time.Time{}, fmt.Errorf("Invalid request body: %v", err)
+	}
+	u, d := body.Until, body.For
+	switch {
+	case u != "" && d != "":
+		return time.Time{}, errors.New("Only one of the until and for fields can be set")
+	case u != "":
+		until, err := time.Parse(time.RFC3339, u)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid until '%s', expecting a RFC 3339 time: %v", u, err)
+		}
+		if !until.After(now) {
+			return time.Time{}, fmt.Errorf("Invalid until '%s', expecting a time in the future", u)
+		}
+		return until, nil
+	case d != "":
+		dur, err := time.ParseDuration(d)
+		if err != nil || dur <= 0 {
+			return time.Time{}, fmt.Errorf("Invalid for '%s', expecting a positive duration, e.g 2h", d)
+		}
+		return now.Add(dur), nil
+	}
+	return time.Time{}, errors.New("Missing until or for field")
+}
@@ -0,0 +1,115 @@
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleProbesControl(t *testing.T) {
+	a, _ := testAPI(t)
+
+	tests := []struct {
+		path     string
+		body     string
+		code     int
+		paused   bool
+		silenced bool
+	}{
+		{"/api/v1/probes/web/pause", "", http.StatusOK, true, false},
+		{"/api/v1/probes/web/silence", `{"for": "2h"}`, http.StatusOK, true, true},
+		{"/api/v1/probes/web/resume", "", http.StatusOK, false, true},
+		{"/api/v1/probes/web/unsilence", "", http.StatusOK, false, false},
+		{"/api/v1/probes/web/silence", "", http.StatusBadRequest, false, false},
+		{"/api/v1/probes/web/silence", `{"for": "-1h"}`, http.StatusBadRequest, false, false},
+		{"/api/v1/probes/unknown/pause", "", http.StatusNotFound, false, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.HandleProbes(w, jsonRequest("POST", tt.path, tt.body))
+		if w.Code != tt.code {
+			t.Errorf("%s: Got: %d\n Want: %d", tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var v ProbeView
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("%s: Error: %v", tt.path, err)
+		}
+		if v.Name != "web" || v.Paused != tt.paused || (v.SilencedUntil != nil) != tt.silenced {
+			t.Errorf("%s: Got: %+v", tt.path, v)
+		}
+	}
+
+	// the requests not sent as json, e.g by a cross-site form, are refused.
+	for _, ct := range []string{"", "application/x-www-form-urlencoded", "text/plain"} {
+		r := httptest.NewRequest("POST", "/api/v1/probes/web/silence", strings.NewReader("for=2h"))
+		r.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		a.HandleProbes(w, r)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: Got: %d\n Want: %d", ct, w.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+	if c := a.sched.(*fakeScheduler).controls["web"]; c.SilencedUntil != nil {
+		t.Errorf("Got: %+v\n Want the probe not silenced", c)
+	}
+
+	// the list tells the paused, silenced and in maintenance probes.
+	a.sched.Pause("ssh", true)
+	a.sched.(*fakeScheduler).controls["web"] = ProbeControl{Maintenance: "nights"}
+	w := httptest.NewRecorder()
+	a.HandleProbes(w, httptest.NewRequest("GET", "/api/v1/probes", nil))
+	var resp struct {
+		Summary map[string]int `json:"summary"`
+		Probes  []ProbeView    `json:"probes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.Summary["paused"] != 1 || resp.Summary["silenced"] != 0 || resp.Summary["in_maintenance"] != 1 || resp.Probes[0].Paused || !resp.Probes[1].Paused {
+		t.Errorf("Got: %+v", resp)
+	}
+}
+
+func TestSilenceUntil(t *testing.T) {
+	now := time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		body string
+		want time.Time // zero for an error.
+	}{
+		{`{"for": "90m"}`, now.Add(90 * time.Minute)},
+		{`{"until": "2016-01-02T18:00:00Z"}`, now.Add(3 * time.Hour)},
+		{`{"until": "2016-01-02T19:00:00+01:00"}`, now.Add(3 * time.Hour)},
+		{`{"until": "2016-01-02T14:00:00Z"}`, time.Time{}},
+		{`{"until": "tomorrow"}`, time.Time{}},
+		{`{"for": "0s"}`, time.Time{}},
+		{`{"for": "1h", "until": "2016-01-02T18:00:00Z"}`, time.Time{}},
+		{`{"for": 3600}`, time.Time{}},
+		{"for=1h", time.Time{}},
+		{"{}", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, tt := range tests {
+		got, err := silenceUntil(jsonRequest("POST", "/api/v1/probes/web/silence", tt.body), now)
+		if tt.want.IsZero() {
+			if err == nil {
+				t.Errorf("%s: Expecting error, but test is passing", tt.body)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: Got: %v, %v\n Want: %v", tt.body, got, err, tt.want)
+		}
+	}
+
+	// the parameters are not taken from the query.
+	if got, err := silenceUntil(jsonRequest("POST", "/api/v1/probes/web/silence?for=1h", ""), now); err == nil {
+		t.Errorf("Got: %v\n Want an error", got)
+	}
+}
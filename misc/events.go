@@ -0,0 +1,183 @@
+package misc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventBuffer is the default number of events buffered for each client of the event stream.
+const DefaultEventBuffer = 256
+
+// keepAliveInterval is how often a comment is sent to the idle clients of the event stream, so that the proxies
+// in between do not close the connection.
+const keepAliveInterval = 15 * time.Second
+
+// ProbeEvent is the result of a probe run, as streamed by /api/v1/events.
+type ProbeEvent struct {
+	ProbeName string            `json:"probe_name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	HistoryEntry
+}
+
+// eventFilter selects the events sent to a client.
+type eventFilter struct {
+	names  []string // probe name globs.
+	labels map[string]string
+}
+
+func newEventFilter(q url.Values) (eventFilter, error) {
+	f := eventFilter{names: q["probe"], labels: make(map[string]string)}
+	for _, g := range f.names {
+		if _, err := path.Match(g, ""); err != nil {
+			return f, fmt.Errorf("Invalid probe filter '%s': %v", g, err)
+		}
+	}
+	for _, l := range q["label"] {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return f, fmt.Errorf("Invalid label filter '%s', expecting key=value", l)
+		}
+		f.labels[kv[0]] = kv[1]
+	}
+	return f, nil
+}
+
+func (f eventFilter) match(e *ProbeEvent) bool {
+	if len(f.names) > 0 {
+		matched := false
+		for _, g := range f.names {
+			if ok, _ := path.Match(g, e.ProbeName); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, v := range f.labels {
+		if lv, ok := e.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// eventClient is a client of the event stream.
+type eventClient struct {
+	filter  eventFilter
+	events  chan *ProbeEvent
+	dropped int // events dropped since the last one sent, the buffer being full. Guarded by the broker lock.
+}
+
+// EventBroker fans out the probe results to the clients of the event stream. Each client has its own bounded buffer,
+// the events being dropped for the clients whose buffer is full, so that a slow client does not hold up the probes.
+type EventBroker struct {
+	bufferSize int
+	lock       sync.Mutex
+	clients    map[*eventClient]bool
+}
+
+// NewEventBroker returns a broker buffering up to bufferSize events for each client.
+func NewEventBroker(bufferSize int) *EventBroker {
+	if bufferSize < 1 {
+		bufferSize = DefaultEventBuffer
+	}
+	return &EventBroker{bufferSize: bufferSize, clients: make(map[*eventClient]bool)}
+}
+
+// Publish sends the given event to the clients whose filter it matches. It never blocks.
+func (b *EventBroker) Publish(e *ProbeEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for c := range b.clients {
+		if !c.filter.match(e) {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			c.dropped++
+		}
+	}
+}
+
+func (b *EventBroker) subscribe(f eventFilter) *eventClient {
+	c := &eventClient{filter: f, events: make(chan *ProbeEvent, b.bufferSize)}
+	b.lock.Lock()
+	b.clients[c] = true
+	b.lock.Unlock()
+	return c
+}
+
+func (b *EventBroker) unsubscribe(c *eventClient) {
+	b.lock.Lock()
+	delete(b.clients, c)
+	b.lock.Unlock()
+}
+
+// takeDropped returns the number of events dropped for the client and resets it.
+func (b *EventBroker) takeDropped(c *eventClient) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := c.dropped
+	c.dropped = 0
+	return n
+}
+
+// HandleEvents serves /api/v1/events, a Server-Sent Events stream of the probe results. Each result is sent as a
+// result event whose data is a ProbeEvent. The events can be filtered by the probe query parameter, a probe name glob,
+// and the label one, as key=value. Both can be given more than once. A dropped event, whose data is the number of
+// events dropped, is sent once the client catches up after its buffer got full.
+func (b *EventBroker) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	f, err := newEventFilter(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := b.subscribe(f)
+	defer b.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // no buffering by nginx.
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-c.events:
+			if n := b.takeDropped(c); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", n)
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: result\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package misc
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventFilter(t *testing.T) {
+	e := &ProbeEvent{ProbeName: "web_prod", Labels: map[string]string{"env": "prod"}}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"probe=web_*", true},
+		{"probe=db_*&probe=web_prod", true},
+		{"probe=db_*", false},
+		{"label=env%3Dprod", true},
+		{"probe=web_*&label=env%3Ddev", false},
+		{"label=team%3Dweb", false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := newEventFilter(q)
+		if err != nil {
+			t.Fatalf("%s: Error: %v", tt.query, err)
+		}
+		if got := f.match(e); got != tt.want {
+			t.Errorf("%s: Got: %v\n Want: %v", tt.query, got, tt.want)
+		}
+	}
+	for _, query := range []string{"probe=%5B", "label=env"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := newEventFilter(q); err == nil {
+			t.Errorf("Expecting error for filter %s, but test is passing", query)
+		}
+	}
+}
+
+func TestEventBrokerPublish(t *testing.T) {
+	b := NewEventBroker(2)
+	c := b.subscribe(eventFilter{})
+	other := b.subscribe(eventFilter{names: []string{"other"}})
+
+	// the publisher is not blocked by the full buffer.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 5; i++ {
+			b.Publish(&ProbeEvent{ProbeName: "probe1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full client buffer")
+	}
+	if len(c.events) != 2 || len(other.events) != 0 {
+		t.Errorf("Got buffered events: %d, %d\n Want: 2, 0", len(c.events), len(other.events))
+	}
+	if n := b.takeDropped(c); n != 3 {
+		t.Errorf("Got dropped: %d\n Want: 3", n)
+	}
+	if n := b.takeDropped(c); n != 0 {
+		t.Errorf("Got dropped: %d\n Want: 0", n)
+	}
+
+	b.unsubscribe(c)
+	b.unsubscribe(other)
+	if len(b.clients) != 0 {
+		t.Errorf("Got %d clients\n Want none", len(b.clients))
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	b := NewEventBroker(10)
+	ts := httptest.NewServer(http.HandlerFunc(b.HandleEvents))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?probe=probe1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Got content type: %s", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if l, _ := r.ReadString('\n'); l != ": connected\n" {
+		t.Fatalf("Got: %q", l)
+	}
+
+	latency := float64(12)
+	b.Publish(&ProbeEvent{ProbeName: "probe2"})
+	b.Publish(&ProbeEvent{ProbeName: "probe1", Labels: map[string]string{"env": "prod"}, HistoryEntry: HistoryEntry{Result: "success", Latency: &latency}})
+
+	var event, data string
+	for data == "" {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "event: ") {
+			event = strings.TrimPrefix(l, "event: ")
+		} else if strings.HasPrefix(l, "data: ") {
+			data = strings.TrimPrefix(l, "data: ")
+		}
+	}
+	var e ProbeEvent
+	if err = json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if event != "result" || e.ProbeName != "probe1" || e.Result != "success" || *e.Latency != latency || e.Labels["env"] != "prod" {
+		t.Errorf("Got: %s %+v", event, e)
+	}
+
+	resp, err = http.Get(ts.URL + "?label=invalid")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got: %d\n Want: %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,94 @@
+package misc
+
+// DefaultHistorySize is the default number of runs kept in the history of each probe.
+const DefaultHistorySize = 100
+
+// HistoryEntry is the outcome of a probe run as kept in the probe history.
+type HistoryEntry struct {
+	StartTime  int64    `json:"start_time"`            // Unix epoch in nano seconds.
+	EndTime    int64    `json:"end_time"`              // Unix epoch in nano seconds.
+	Result     string   `json:"result"`                // one of success, failure, error or timeout.
+	State      string   `json:"state,omitempty"`       // the debounced state after the run.
+	Latency    *float64 `json:"latency,omitempty"`     // in milli seconds, if the probe responded.
+	Error      string   `json:"error,omitempty"`       // the probe error, if any.
+	StatusCode int      `json:"status_code,omitempty"` // the http status code, for the http module.
+	Excluded   string   `json:"excluded,omitempty"`    // the maintenance window of a failed run left out of the metrics and the state.
+	Attempts   int      `json:"attempts,omitempty"`    // the number of attempts, if the run was retried.
+}
+
+// history is a ring buffer of the last runs of a probe.
+type history struct {
+	entries []HistoryEntry
+	next    int  // index of the next entry to write.
+	full    bool // whether the buffer has wrapped around.
+}
+
+func newHistory(size int) *history {
+	return &history{entries: make([]HistoryEntry, size)}
+}
+
+func (h *history) add(e HistoryEntry) {
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the entries, oldest first.
+func (h *history) list() []HistoryEntry {
+	if !h.full {
+		return append([]HistoryEntry(nil), h.entries[:h.next]...)
+	}
+	return append(append([]HistoryEntry(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// SetHistorySize sets the number of runs kept in the history of each probe. It needs to be called before any run
+// is added.
+func (ps *ProbesStatus) SetHistorySize(n int) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.historySize = n
+}
+
+// AddHistory adds the outcome of a probe run to the history of the probe.
+func (ps *ProbesStatus) AddHistory(pn string, e HistoryEntry) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.history(pn).add(e)
+}
+
+// history returns the history of the given probe, creating it if needed. Needs the lock to be held.
+func (ps *ProbesStatus) history(pn string) *history {
+	h, ok := ps.histories[pn]
+	if !ok {
+		size := ps.historySize
+		if size < 1 {
+			size = DefaultHistorySize
+		}
+		h = newHistory(size)
+		ps.histories[pn] = h
+	}
+	return h
+}
+
+// ReadHistory returns the history of the given probe, oldest first.
+func (ps *ProbesStatus) ReadHistory(pn string) []HistoryEntry {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	if h, ok := ps.histories[pn]; ok {
+		return h.list()
+	}
+	return nil
+}
+
+// hasProbe tells whether the given probe is known.
+func (ps *ProbesStatus) hasProbe(pn string) bool {
+	for _, p := range ps.Probes {
+		if p == pn {
+			return true
+		}
+	}
+	return false
+}
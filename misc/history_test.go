@@ -0,0 +1,69 @@
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	ps := NewProbesStatus([]string{"probe1"})
+	ps.SetHistorySize(3)
+	if got := ps.ReadHistory("probe1"); got != nil {
+		t.Errorf("Got: %v\n Want: nil", got)
+	}
+	for i := int64(1); i <= 5; i++ {
+		ps.AddHistory("probe1", HistoryEntry{StartTime: i, Result: "success"})
+		got := ps.ReadHistory("probe1")
+		want := i
+		if want > 3 {
+			want = 3
+		}
+		if int64(len(got)) != want || got[len(got)-1].StartTime != i || got[0].StartTime != i-want+1 {
+			t.Errorf("Run %d: Got: %+v", i, got)
+		}
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	a, ps := testAPI(t)
+	ps.AddHistory("web", HistoryEntry{StartTime: 2, Result: "timeout", Error: "Timed out after 5 seconds"})
+
+	tests := []struct {
+		method, path string
+		code         int
+		want         int // number of entries.
+	}{
+		{"GET", "/api/probes/web/history", http.StatusOK, 2},
+		{"GET", "/api/probes/ssh/history", http.StatusOK, 0},
+		{"GET", "/api/probes/unknown/history", http.StatusNotFound, 0},
+		{"GET", "/api/probes/web", http.StatusNotFound, 0},
+		{"POST", "/api/probes/web/pause", http.StatusNotFound, 0},
+		{"POST", "/api/probes/web/history", http.StatusMethodNotAllowed, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.HandleHistory(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: Got code: %d\n Want: %d", tt.method, tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			ProbeName string         `json:"probe_name"`
+			History   []HistoryEntry `json:"history"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if len(resp.History) != tt.want || resp.History == nil {
+			t.Errorf("%s: Got: %+v\n Want %d entries", tt.path, resp, tt.want)
+		}
+	}
+	if a.sched.Control("web").Paused {
+		t.Error("Paused a probe via the history path")
+	}
+}
@@ -0,0 +1,104 @@
+package misc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/samitpal/goProbe/conf"
+	"github.com/samitpal/goProbe/modules"
+	"net/http"
+	"time"
+)
+
+const (
+	maxTestConfig  = 1 << 20          // max size of the probe config posted to /api/v1/test.
+	maxTestRetries = 2                // max retries of a probe run by /api/v1/test.
+	maxTestRunTime = 30 * time.Second // max time of a run by /api/v1/test, including the retries.
+)
+
+// RunResult is the outcome of an on demand run of a probe, as returned by the run and test handlers.
+type RunResult struct {
+	ProbeName string             `json:"probe_name"`
+	Result    string             `json:"result"` // one of success, failure, error or timeout.
+	Error     string             `json:"error,omitempty"`
+	State     string             `json:"state,omitempty"` // the state after the run, only for the scheduled probes.
+	Attempts  int                `json:"attempts"`        // more than 1 if the run was retried.
+	StartTime time.Time          `json:"start_time"`
+	EndTime   time.Time          `json:"end_time"`
+	Data      *modules.ProbeData `json:"probe_data,omitempty"` // the probe response, for the success and failure results. The payload is cut to maxBody.
+}
+
+// Runner runs the probes on demand.
+type Runner interface {
+	// RunProbe runs the given scheduled probe now. The run is recorded like a scheduled one.
+	RunProbe(p modules.Prober) *RunResult
+
+	// TestProbe runs the given probe once. The run is not recorded. It returns nil if a stop signal is received in
+	// the meantime.
+	TestProbe(p modules.Prober, stopCh chan bool) *RunResult
+}
+
+// EnableTest enables POST /api/v1/test, which is disabled by default since it runs any probe config it is sent.
+func (a *API) EnableTest() {
+	a.testEnabled = true
+}
+
+// writeRunResult writes the given run result, the payload of the probe response being cut to maxBody.
+func writeRunResult(w http.ResponseWriter, res *RunResult) {
+	if res.Data != nil && res.Data.Payload != nil && len(*res.Data.Payload) > maxBody {
+		data := *res.Data // shared with the probe status.
+		payload := (*data.Payload)[:maxBody]
+		data.Payload = &payload
+		cut := *res
+		cut.Data = &data
+		res = &cut
+	}
+	writeJSON(w, res)
+}
+
+// HandleTest serves POST /api/v1/test, which runs the posted probe config once and returns the outcome, without adding
+// the probe to the scheduled ones. The config is a probe entry of the config file, e.g
+//
+//	{"probe_type": "http", "probe_config": {"probe_name": "test", "probe_url": "http://example.com"}}
+//
+// The run is given up after maxTestRunTime or once the client goes away. It is only served once enabled, see
+// EnableTest.
+func (a *API) HandleTest(w http.ResponseWriter, r *http.Request) {
+	if !a.testEnabled {
+		writeJSONError(w, "The test api is not enabled", http.StatusNotFound)
+		return
+	}
+	if !allowMethods(w, r, "POST") || !requireJSON(w, r) {
+		return
+	}
+	var c conf.Probes
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTestConfig)).Decode(&c); err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid probe config: %v", err), http.StatusBadRequest)
+		return
+	}
+	p, err := conf.SetupProbe(c)
+	if err == nil {
+		err = CheckProbeConfig([]modules.Prober{p})
+	}
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Error in probe config: %v", err), http.StatusBadRequest)
+		return
+	}
+	if p.Options().Retries > maxTestRetries {
+		writeJSONError(w, fmt.Sprintf("Too many retries, a test run can be retried up to %d times", maxTestRetries), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxTestRunTime)
+	defer cancel()
+	stopCh, release := StopChannel(ctx)
+	defer release()
+	res := a.sched.TestProbe(p, stopCh)
+	if res == nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			writeJSONError(w, fmt.Sprintf("The test run did not finish within %v", maxTestRunTime), http.StatusGatewayTimeout)
+		}
+		return // the client went away.
+	}
+	writeRunResult(w, res)
+}
@@ -0,0 +1,155 @@
+package misc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/samitpal/goProbe/modules"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeScheduler records the probes it is asked to run along with their control.
+type fakeScheduler struct {
+	run, tested []string
+	controls    map[string]ProbeControl
+}
+
+func (f *fakeScheduler) RunProbe(p modules.Prober) *RunResult {
+	f.run = append(f.run, *p.Name())
+	return &RunResult{ProbeName: *p.Name(), Result: "success", State: StateUp}
+}
+
+func (f *fakeScheduler) TestProbe(p modules.Prober, stopCh chan bool) *RunResult {
+	select {
+	case <-stopCh:
+		return nil
+	case <-time.After(20 * time.Millisecond):
+	}
+	f.tested = append(f.tested, *p.Name())
+	up, latency, payload := float64(0), float64(12), make([]byte, 2*maxBody)
+	return &RunResult{ProbeName: *p.Name(), Result: "failure", Data: &modules.ProbeData{IsUp: &up, Latency: &latency, Payload: &payload}}
+}
+
+func (f *fakeScheduler) Control(pn string) ProbeControl {
+	return f.controls[pn]
+}
+
+func (f *fakeScheduler) Pause(pn string, paused bool) {
+	c := f.controls[pn]
+	c.Paused = paused
+	f.controls[pn] = c
+}
+
+func (f *fakeScheduler) Silence(pn string, until time.Time) {
+	c := f.controls[pn]
+	c.SilencedUntil = nil
+	if !until.IsZero() {
+		c.SilencedUntil = &until
+	}
+	f.controls[pn] = c
+}
+
+func TestHandleProbesRun(t *testing.T) {
+	a, _ := testAPI(t)
+	f := a.sched.(*fakeScheduler)
+
+	w := httptest.NewRecorder()
+	a.HandleProbes(w, jsonRequest("POST", "/api/v1/probes/web/run", ""))
+	var res RunResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if w.Code != http.StatusOK || res.ProbeName != "web" || res.State != StateUp || len(f.run) != 1 {
+		t.Errorf("Got: %d, %+v, %v", w.Code, res, f.run)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/api/v1/probes/web/run", http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/probes/unknown/run", http.StatusNotFound},
+		{"POST", "/api/v1/probes/web", http.StatusMethodNotAllowed},
+	} {
+		w = httptest.NewRecorder()
+		a.HandleProbes(w, jsonRequest(tt.method, tt.path, ""))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: Got: %d\n Want: %d", tt.method, tt.path, w.Code, tt.code)
+		}
+	}
+	if len(f.run) != 1 {
+		t.Errorf("Got runs: %v\n Want: [web]", f.run)
+	}
+}
+
+func TestHandleTest(t *testing.T) {
+	a, _ := testAPI(t)
+	f := a.sched.(*fakeScheduler)
+	body := `{"probe_type": "ping_port", "probe_config": {"probe_name": "adhoc", "probe_host_name": "example.com", "probe_host_port": 443}}`
+
+	// the test api is disabled by default.
+	w := httptest.NewRecorder()
+	a.HandleTest(w, jsonRequest("POST", "/api/v1/test", body))
+	if w.Code != http.StatusNotFound || len(f.tested) != 0 {
+		t.Errorf("Got: %d, %v\n Want: %d", w.Code, f.tested, http.StatusNotFound)
+	}
+	a.EnableTest()
+
+	w = httptest.NewRecorder()
+	a.HandleTest(w, jsonRequest("POST", "/api/v1/test", body))
+	var res RunResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if w.Code != http.StatusOK || res.ProbeName != "adhoc" || res.Result != "failure" || len(f.tested) != 1 {
+		t.Errorf("Got: %d, %+v, %v", w.Code, res, f.tested)
+	}
+	if res.Data == nil || res.Data.Payload == nil || len(*res.Data.Payload) != maxBody {
+		t.Errorf("Got probe data: %+v\n Want the payload cut to %d bytes", res.Data, maxBody)
+	}
+	// the tested probe is not added to the api.
+	if _, ok := a.byName["adhoc"]; ok {
+		t.Error("Expecting the tested probe not to be listed")
+	}
+
+	for _, tt := range []struct {
+		method, body string
+		code         int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "{", http.StatusBadRequest},
+		{"POST", `{"probe_type": "invalid", "probe_config": {"probe_name": "adhoc"}}`, http.StatusBadRequest},
+		{"POST", `{"probe_type": "http", "probe_config": {"probe_name": "adhoc"}}`, http.StatusBadRequest},
+		{"POST", `{"probe_type": "http", "probe_config": {"probe_name": "adhoc", "probe_url": "http://example.com", "probe_timeout": 0}}`, http.StatusBadRequest},
+		{"POST", `{"probe_type": "http", "probe_config": {"probe_name": "adhoc", "probe_url": "http://example.com", "probe_timeout": "@hourly"}}`, http.StatusBadRequest},
+		{"POST", `{"probe_type": "http", "probe_config": {"probe_name": "adhoc", "probe_url": "http://example.com", "retries": 1000000, "retry_delay": "1h"}}`, http.StatusBadRequest},
+	} {
+		w = httptest.NewRecorder()
+		a.HandleTest(w, jsonRequest(tt.method, "/api/v1/test", tt.body))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: Got: %d\n Want: %d", tt.method, tt.body, w.Code, tt.code)
+		}
+	}
+	if len(f.tested) != 1 {
+		t.Errorf("Got tests: %v\n Want: [adhoc]", f.tested)
+	}
+
+	// the probe is not tested if not sent as json.
+	w = httptest.NewRecorder()
+	a.HandleTest(w, httptest.NewRequest("POST", "/api/v1/test", strings.NewReader(body)))
+	if w.Code != http.StatusUnsupportedMediaType || len(f.tested) != 1 {
+		t.Errorf("Got: %d, %v\n Want: %d", w.Code, f.tested, http.StatusUnsupportedMediaType)
+	}
+
+	// the run is given up once the client goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	a.HandleTest(w, jsonRequest("POST", "/api/v1/test", body).WithContext(ctx))
+	if len(f.tested) != 1 {
+		t.Errorf("Got tests: %v\n Want: [adhoc]", f.tested)
+	}
+}
@@ -0,0 +1,120 @@
+package misc
+
+import (
+	"errors"
+	"github.com/samitpal/goProbe/modules"
+	"time"
+)
+
+// The debounced states of a probe.
+const (
+	StateUnknown     = "unknown" // no result yet, or not enough failed runs yet to go down.
+	StateUp          = "up"
+	StateDown        = "down"
+	StateUnreachable = "unreachable" // down while a probe it depends on is down or unreachable.
+	StateFlapping    = "flapping"    // going up and down too often.
+)
+
+// States lists the debounced states of a probe.
+var States = []string{StateUnknown, StateUp, StateDown, StateUnreachable, StateFlapping}
+
+// StateConfig sets how the state of a probe follows the results of its runs.
+type StateConfig struct {
+	FailuresBeforeDown int           // consecutive failed runs for the probe to go down.
+	SuccessesBeforeUp  int           // consecutive successful runs for a down probe to come back up.
+	FlapWindow         time.Duration // the window within which the state changes are counted.
+	FlapThreshold      int           // state changes within the window for the probe to be flapping. 0 disables flap detection.
+}
+
+// NewStateConfig returns the state config of the given probe options. The options not set by the probe are
+// taken from the given defaults.
+func NewStateConfig(o *modules.ProbeOptions, defaults StateConfig) (StateConfig, error) {
+	c := defaults
+	if o.FailuresBeforeDown < 0 || o.SuccessesBeforeUp < 0 || o.FlapThreshold < 0 {
+		return c, errors.New("failures_before_down, successes_before_up and flap_threshold can not be negative")
+	}
+	if o.FailuresBeforeDown > 0 {
+		c.FailuresBeforeDown = o.FailuresBeforeDown
+	}
+	if o.SuccessesBeforeUp > 0 {
+		c.SuccessesBeforeUp = o.SuccessesBeforeUp
+	}
+	if o.FlapThreshold > 0 {
+		c.FlapThreshold = o.FlapThreshold
+	}
+	if o.FlapWindow != "" {
+		w, err := time.ParseDuration(o.FlapWindow)
+		if err != nil {
+			return c, err
+		}
+		c.FlapWindow = w
+	}
+	return c, nil
+}
+
+// ProbeState is the debounced state of a probe. A probe goes down after FailuresBeforeDown consecutive failed
+// runs and comes back up after SuccessesBeforeUp consecutive successful ones. It is flapping as long as it went up
+// or down at least FlapThreshold times within the last FlapWindow.
+type ProbeState struct {
+	State     string    // one of unknown, up or down.
+	Since     time.Time // when the probe went to its current state.
+	Flapping  bool
+	Failures  int // consecutive failed runs.
+	Successes int // consecutive successful runs.
+	changes   []time.Time
+}
+
+// NewProbeState returns the state of a probe with no run yet.
+func NewProbeState(now time.Time) *ProbeState {
+	return &ProbeState{State: StateUnknown, Since: now}
+}
+
+// Current returns the state of the probe, i.e flapping if it is flapping, otherwise its up/down state.
+func (s *ProbeState) Current() string {
+	if s.Flapping {
+		return StateFlapping
+	}
+	return s.State
+}
+
+// Update updates the state with the result of a probe run. It returns whether the current state has changed.
+// Thresholds below 1 are taken as 1.
+func (s *ProbeState) Update(c StateConfig, success bool, now time.Time) bool {
+	if c.FailuresBeforeDown < 1 {
+		c.FailuresBeforeDown = 1
+	}
+	if c.SuccessesBeforeUp < 1 {
+		c.SuccessesBeforeUp = 1
+	}
+	prev := s.Current()
+	if success {
+		s.Successes++
+		s.Failures = 0
+	} else {
+		s.Failures++
+		s.Successes = 0
+	}
+
+	switch {
+	case s.State != StateDown && s.Failures >= c.FailuresBeforeDown:
+		if s.State == StateUp {
+			s.changes = append(s.changes, now)
+		}
+		s.State, s.Since = StateDown, now
+	case s.State == StateDown && s.Successes >= c.SuccessesBeforeUp:
+		s.changes = append(s.changes, now)
+		s.State, s.Since = StateUp, now
+	case s.State == StateUnknown && success:
+		s.State, s.Since = StateUp, now
+	}
+
+	// Only the changes within the flap window are kept.
+	i := 0
+	for i < len(s.changes) && now.Sub(s.changes[i]) > c.FlapWindow {
+		i++
+	}
+	s.changes = s.changes[i:]
+	s.Flapping = c.FlapThreshold > 0 && len(s.changes) >= c.FlapThreshold
+
+	return s.Current() != prev
+}
@@ -0,0 +1,207 @@
+package misc
+
+import (
+	"github.com/samitpal/goProbe/conf"
+	"github.com/samitpal/goProbe/modules"
+	"testing"
+	"time"
+)
+
+func TestProbeStateUpdate(t *testing.T) {
+	c := StateConfig{FailuresBeforeDown: 2, SuccessesBeforeUp: 2}
+	now := time.Unix(1450000000, 0)
+	s := NewProbeState(now)
+	tests := []struct {
+		success bool
+		want    string
+		changed bool
+	}{
+		{false, StateUnknown, false},
+		{true, StateUp, true},
+		{false, StateUp, false},
+		{true, StateUp, false},
+		{false, StateUp, false},
+		{false, StateDown, true},
+		{true, StateDown, false},
+		{false, StateDown, false},
+		{true, StateDown, false},
+		{true, StateUp, true},
+	}
+	for i, tt := range tests {
+		now = now.Add(time.Minute)
+		changed := s.Update(c, tt.success, now)
+		if s.Current() != tt.want || changed != tt.changed {
+			t.Errorf("Run %d: Got: %s, %v\n Want: %s, %v", i, s.Current(), changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestProbeStateFlapping(t *testing.T) {
+	c := StateConfig{FailuresBeforeDown: 1, SuccessesBeforeUp: 1, FlapWindow: 10 * time.Minute, FlapThreshold: 3}
+	now := time.Unix(1450000000, 0)
+	s := NewProbeState(now)
+	s.Update(c, true, now)
+
+	// up, down, up and down again within the window.
+	for i, success := range []bool{false, true, false} {
+		now = now.Add(time.Minute)
+		s.Update(c, success, now)
+		if i < 2 && s.Flapping {
+			t.Errorf("Run %d: Got flapping, Want not flapping", i)
+		}
+	}
+	if s.Current() != StateFlapping {
+		t.Errorf("Got: %s\n Want: %s", s.Current(), StateFlapping)
+	}
+
+	// stable runs till the state changes are out of the window.
+	now = now.Add(5 * time.Minute)
+	if s.Update(c, false, now); !s.Flapping {
+		t.Errorf("Got: %s\n Want: %s", s.Current(), StateFlapping)
+	}
+	now = now.Add(5 * time.Minute)
+	if changed := s.Update(c, false, now); !changed || s.Current() != StateDown {
+		t.Errorf("Got: %s, %v\n Want: %s, true", s.Current(), changed, StateDown)
+	}
+}
+
+func TestNewStateConfig(t *testing.T) {
+	defaults := StateConfig{FailuresBeforeDown: 1, SuccessesBeforeUp: 1, FlapWindow: 30 * time.Minute, FlapThreshold: 6}
+	c, err := NewStateConfig(&modules.ProbeOptions{FailuresBeforeDown: 3, FlapWindow: "1h"}, defaults)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want := StateConfig{FailuresBeforeDown: 3, SuccessesBeforeUp: 1, FlapWindow: time.Hour, FlapThreshold: 6}
+	if c != want {
+		t.Errorf("Got: %+v\n Want: %+v", c, want)
+	}
+	for _, o := range []*modules.ProbeOptions{{FlapWindow: "1x"}, {FailuresBeforeDown: -1}} {
+		if _, err := NewStateConfig(o, defaults); err == nil {
+			t.Errorf("Expecting error for options %+v, but test is passing", o)
+		}
+	}
+}
+
+func TestProbesStatusState(t *testing.T) {
+	ps := NewProbesStatus([]string{"probe1"})
+	up, down := float64(1), float64(0)
+	st := int64(1450000000000000000)
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &up}, st, st)
+	if got := ps.ReadProbeStatus("probe1"); got.State != StateUp || got.StateSince != st {
+		t.Errorf("Got: %s, %d\n Want: %s, %d", got.State, got.StateSince, StateUp, st)
+	}
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &down}, st+1, st+1)
+	if got := ps.ReadProbeStatus("probe1"); got.State != StateDown {
+		t.Errorf("Got: %s\n Want: %s", got.State, StateDown)
+	}
+	ps.WriteProbeTimeoutStatus("probe1", st+2, st+2)
+	if got := ps.ReadProbeStatus("probe1"); got.State != StateDown || got.StateSince != st+1 || !got.ProbeTimeout {
+		t.Errorf("Got: %+v\n Want: down since %d", got, st+1)
+	}
+}
+
+// dependentProbesStatus returns the status of a web probe depending on a switch, itself depending on a router, along
+// with a function writing the result of a run of one of them and returning its new state.
+func dependentProbesStatus(t *testing.T, c StateConfig) (*ProbesStatus, func(pn string, isUp *float64) string) {
+	probes, err := conf.SetupConfig([]byte(`[
+		{"probe_type": "ping_port", "probe_config": {"probe_name": "router", "probe_host_name": "router", "probe_host_port": 22}},
+		{"probe_type": "ping_port", "probe_config": {"probe_name": "switch", "probe_host_name": "switch", "probe_host_port": 22, "depends_on": ["router"]}},
+		{"probe_type": "http", "probe_config": {"probe_name": "web", "probe_url": "http://example.com", "depends_on": ["switch"]}}
+	]`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ps := NewProbesStatus(conf.GetProbeNames(probes))
+	if err = ps.SetStateConfigs(probes, c); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	st := int64(1450000000000000000)
+	return ps, func(pn string, isUp *float64) string {
+		st++
+		ps.WriteProbeStatus(pn, &modules.ProbeData{IsUp: isUp}, st, st)
+		return ps.ReadProbeStatus(pn).State
+	}
+}
+
+func TestProbesStatusUnreachable(t *testing.T) {
+	ps, write := dependentProbesStatus(t, StateConfig{})
+	up, down := float64(1), float64(0)
+
+	// the web probe is down as long as the switch and the router are up.
+	write("router", &up)
+	write("switch", &up)
+	if got := write("web", &down); got != StateDown {
+		t.Errorf("Got: %s\n Want: %s", got, StateDown)
+	}
+	// the switch is unreachable, and so is the web probe, once the router is down.
+	write("router", &down)
+	if got := write("switch", &down); got != StateUnreachable {
+		t.Errorf("Got: %s\n Want: %s", got, StateUnreachable)
+	}
+	if got := write("web", &down); got != StateUnreachable || ps.DownParent("web") != "switch" {
+		t.Errorf("Got: %s, %s\n Want: %s, switch", got, ps.DownParent("web"), StateUnreachable)
+	}
+	// the web probe is down again once the router and the switch are back up.
+	write("router", &up)
+	write("switch", &up)
+	if got := write("web", &down); got != StateDown || ps.DownParent("web") != "" {
+		t.Errorf("Got: %s, %s\n Want: %s", got, ps.DownParent("web"), StateDown)
+	}
+}
+
+func TestProbesStatusUnreachableFlapping(t *testing.T) {
+	up, down := float64(1), float64(0)
+	flapping := StateConfig{FlapWindow: time.Hour, FlapThreshold: 2}
+
+	// a flapping parent which is down makes its children unreachable.
+	ps, write := dependentProbesStatus(t, flapping)
+	write("switch", &up)
+	for _, isUp := range []*float64{&up, &down, &up, &down} {
+		write("router", isUp)
+	}
+	if got := ps.ReadProbeStatus("router").State; got != StateFlapping {
+		t.Fatalf("Got: %s\n Want: %s", got, StateFlapping)
+	}
+	if got := write("switch", &down); got != StateUnreachable || ps.DownParent("switch") != "router" {
+		t.Errorf("Got: %s, %s\n Want: %s, router", got, ps.DownParent("switch"), StateUnreachable)
+	}
+	// but not once it is flapping up.
+	write("router", &up)
+	if got := write("switch", &down); got != StateDown || ps.DownParent("switch") != "" {
+		t.Errorf("Got: %s, %s\n Want: %s", got, ps.DownParent("switch"), StateDown)
+	}
+
+	// a flapping child which is down is unreachable behind a down parent.
+	ps, write = dependentProbesStatus(t, flapping)
+	write("router", &up)
+	write("switch", &up)
+	for _, isUp := range []*float64{&up, &down, &up} {
+		write("web", isUp)
+	}
+	if got := write("web", &down); got != StateFlapping {
+		t.Fatalf("Got: %s\n Want: %s", got, StateFlapping)
+	}
+	write("switch", &down)
+	if got := write("web", &down); got != StateUnreachable {
+		t.Errorf("Got: %s\n Want: %s", got, StateUnreachable)
+	}
+	// and flapping again once the parent is back up.
+	write("switch", &up)
+	if got := write("web", &down); got != StateFlapping {
+		t.Errorf("Got: %s\n Want: %s", got, StateFlapping)
+	}
+}
+
+func TestProbesStatusLastFailure(t *testing.T) {
+	ps := NewProbesStatus([]string{"probe1"})
+	up := float64(1)
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &up}, 1, 2)
+	if got := ps.ReadProbeStatus("probe1").LastFailure; got != 0 {
+		t.Errorf("Got: %d\n Want: 0", got)
+	}
+	ps.WriteProbeErrorStatus("probe1", 3, 4)
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &up}, 5, 6)
+	if got := ps.ReadProbeStatus("probe1").LastFailure; got != 4 {
+		t.Errorf("Got: %d\n Want: 4", got)
+	}
+}
@@ -1,6 +1,7 @@
 package misc
 
 import (
+	"fmt"
 	"github.com/samitpal/goProbe/modules"
 	"html/template"
 	"net/http"
@@ -15,6 +16,10 @@ type ProbeStatus struct {
 	ProbeTimeout   bool
 	ProbeStartTime int64
 	ProbeEndTime   int64
+	State          string // the debounced state, see ProbeState.
+	StateSince     int64  // Unix epoch in nano seconds.
+	Failures       int    // consecutive failed runs.
+	LastFailure    int64  // end of the last failed run, Unix epoch in nano seconds. 0 if none.
 }
 
 type TemplateParams struct {
@@ -23,17 +28,86 @@ type TemplateParams struct {
 }
 
 type ProbesStatus struct {
-	Tmpl           TemplateParams
 	Probes         []string
 	ProbeStatusMap map[string]*ProbeStatus
+	states         map[string]*ProbeState
+	stateConfigs   map[string]StateConfig
+	dependsOn      map[string][]string // the probes each probe depends on.
+	histories      map[string]*history
+	historySize    int
 	lock           sync.RWMutex
 }
 
 func NewProbesStatus(p []string) *ProbesStatus {
 	return &ProbesStatus{
-		Tmpl:           TemplateParams{ShowParams: "all", ProbeSingle: ""},
 		Probes:         p,
 		ProbeStatusMap: make(map[string]*ProbeStatus),
+		states:         make(map[string]*ProbeState),
+		stateConfigs:   make(map[string]StateConfig),
+		dependsOn:      make(map[string][]string),
+		histories:      make(map[string]*history),
+		historySize:    DefaultHistorySize,
+	}
+}
+
+// SetStateConfigs sets the state configs and the dependencies of the given probes from their options and the given
+// defaults.
+func (ps *ProbesStatus) SetStateConfigs(probes []modules.Prober, defaults StateConfig) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range probes {
+		c, err := NewStateConfig(p.Options(), defaults)
+		if err != nil {
+			return fmt.Errorf("Probe %s: %v", *p.Name(), err)
+		}
+		ps.stateConfigs[*p.Name()] = c
+		ps.dependsOn[*p.Name()] = p.Options().DependsOn
+	}
+	return nil
+}
+
+// DownParent returns the first of the probes the given probe depends on which is down or unreachable, flapping or
+// not, or an empty string if none.
+func (ps *ProbesStatus) DownParent(pn string) string {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+	return ps.downParent(pn)
+}
+
+// downParent is DownParent with the lock held. It looks at the up/down state of the parents, an unreachable parent
+// being down as well.
+func (ps *ProbesStatus) downParent(pn string) string {
+	for _, parent := range ps.dependsOn[pn] {
+		if s, ok := ps.states[parent]; ok && s.State == StateDown {
+			return parent
+		}
+	}
+	return ""
+}
+
+// updateState updates the debounced state of the probe with the result of a run and sets it in the given status,
+// along with the time of the last failed run. Needs the lock to be held.
+func (ps *ProbesStatus) updateState(pn string, status *ProbeStatus, success bool) {
+	if !success {
+		status.LastFailure = status.ProbeEndTime
+	} else if prev, ok := ps.ProbeStatusMap[pn]; ok {
+		status.LastFailure = prev.LastFailure
+	}
+
+	now := time.Unix(0, status.ProbeEndTime)
+	s, ok := ps.states[pn]
+	if !ok {
+		s = NewProbeState(now)
+		ps.states[pn] = s
+	}
+	s.Update(ps.stateConfigs[pn], success, now)
+	status.State = s.Current()
+	status.StateSince = s.Since.UnixNano()
+	status.Failures = s.Failures
+	// a down probe is unreachable behind a down parent, even if flapping.
+	if s.State == StateDown && ps.downParent(pn) != "" {
+		status.State = StateUnreachable
 	}
 }
 
@@ -41,13 +115,15 @@ func (ps *ProbesStatus) WriteProbeStatus(pn string, pd *modules.ProbeData, st in
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	ps.ProbeStatusMap[pn] = &ProbeStatus{
+	status := &ProbeStatus{
 		ProbeResp:      pd,
 		ProbeError:     false,
 		ProbeTimeout:   false,
 		ProbeStartTime: st,
 		ProbeEndTime:   et,
 	}
+	ps.updateState(pn, status, pd.IsUp != nil && *pd.IsUp == 1)
+	ps.ProbeStatusMap[pn] = status
 
 }
 
@@ -55,13 +131,15 @@ func (ps *ProbesStatus) WriteProbeErrorStatus(pn string, st int64, et int64) {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	ps.ProbeStatusMap[pn] = &ProbeStatus{
+	status := &ProbeStatus{
 		ProbeResp:      nil,
 		ProbeError:     true,
 		ProbeTimeout:   false,
 		ProbeStartTime: st,
 		ProbeEndTime:   et,
 	}
+	ps.updateState(pn, status, false)
+	ps.ProbeStatusMap[pn] = status
 
 }
 
@@ -69,13 +147,15 @@ func (ps *ProbesStatus) WriteProbeTimeoutStatus(pn string, st int64, et int64) {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	ps.ProbeStatusMap[pn] = &ProbeStatus{
+	status := &ProbeStatus{
 		ProbeResp:      nil,
 		ProbeError:     false,
 		ProbeTimeout:   true,
 		ProbeStartTime: st,
 		ProbeEndTime:   et,
 	}
+	ps.updateState(pn, status, false)
+	ps.ProbeStatusMap[pn] = status
 }
 
 func (ps *ProbesStatus) ReadProbeStatus(pn string) *ProbeStatus {
@@ -110,3 +190,64 @@ func (ps *ProbesStatus) FormattedHttpHeaders(h http.Header) template.HTML {
 func (ps *ProbesStatus) FormattedHttpBody(body *[]byte) string {
 	return string(*body)
 }
+
+// SavedStatus is the status of a probe as saved across restarts, see the store package.
+type SavedStatus struct {
+	Status       *ProbeStatus   `json:"status,omitempty"`
+	State        *ProbeState    `json:"state,omitempty"`
+	StateChanges []time.Time    `json:"state_changes,omitempty"` // the state changes within the flap window.
+	History      []HistoryEntry `json:"history,omitempty"`
+}
+
+// Save returns the status, state and history of the given probe, or nil if the probe has not run yet. The response
+// payload is left out.
+func (ps *ProbesStatus) Save(pn string) *SavedStatus {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	status, ok := ps.ProbeStatusMap[pn]
+	if !ok {
+		return nil
+	}
+	saved := &SavedStatus{Status: status}
+	if status.ProbeResp != nil && status.ProbeResp.Payload != nil {
+		resp := *status.ProbeResp
+		resp.Payload = nil
+		st := *status
+		st.ProbeResp = &resp
+		saved.Status = &st
+	}
+	if s, ok := ps.states[pn]; ok {
+		st := *s
+		st.changes = nil
+		saved.State = &st
+		saved.StateChanges = append([]time.Time(nil), s.changes...)
+	}
+	if h, ok := ps.histories[pn]; ok {
+		saved.History = h.list()
+	}
+	return saved
+}
+
+// Restore sets the status, state and history of the given probe from a status returned by Save. It should be
+// called before any probe run.
+func (ps *ProbesStatus) Restore(pn string, saved *SavedStatus) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if saved.Status != nil {
+		ps.ProbeStatusMap[pn] = saved.Status
+	}
+	if saved.State != nil {
+		s := *saved.State
+		s.changes = append([]time.Time(nil), saved.StateChanges...)
+		ps.states[pn] = &s
+	}
+	if len(saved.History) > 0 {
+		delete(ps.histories, pn)
+		h := ps.history(pn)
+		for _, e := range saved.History {
+			h.add(e)
+		}
+	}
+}
@@ -1,16 +1,32 @@
 package misc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/samitpal/goProbe/conf"
 	"github.com/samitpal/goProbe/modules"
+	tmplfs "github.com/samitpal/goProbe/templates"
 	"html/template"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
-var templates = template.Must(template.ParseGlob(os.Getenv("GOPROBE_TMPL")))
+// templates are the html templates of the web pages, the embedded ones unless overridden by SetupTemplates.
+var templates = template.Must(template.ParseFS(tmplfs.FS, "*.html"))
+
+// SetupTemplates overrides the embedded html templates with the ones defined in the files matching the given glob
+// pattern, e.g /etc/goprobe/templates/*.html. The templates not defined in the files are kept. It needs to be called
+// before serving the web pages.
+func SetupTemplates(pattern string) error {
+	t, err := template.Must(template.ParseFS(tmplfs.FS, "*.html")).ParseGlob(pattern)
+	if err != nil {
+		return err
+	}
+	templates = t
+	return nil
+}
 
 // CheckProbeConfig function does sanity checks on the probe definition.
 func CheckProbeConfig(probes []modules.Prober) error {
@@ -18,8 +34,86 @@ func CheckProbeConfig(probes []modules.Prober) error {
 		return errors.New("No probe modules defined")
 	}
 	for _, p := range probes {
-		if *p.TimeoutSecs() > *p.RunIntervalSecs() {
-			return fmt.Errorf("Timeout can not be more than the Interval %v", p.Name())
+		// the probe name is a segment of the api paths, e.g /api/v1/probes/{name}/pause.
+		if strings.Contains(*p.Name(), "/") {
+			return fmt.Errorf("Probe name can not contain a / %v", *p.Name())
+		}
+		interval, timeout := p.RunInterval(), p.Timeout()
+		if interval.Cron == nil && interval.Duration <= 0 {
+			return fmt.Errorf("Interval needs to be positive %v", *p.Name())
+		}
+		if timeout.Cron != nil {
+			return fmt.Errorf("Timeout can not be a cron expression %v", *p.Name())
+		}
+		if timeout.Duration <= 0 {
+			return fmt.Errorf("Timeout needs to be positive %v", *p.Name())
+		}
+		if interval.Cron == nil && timeout.Duration > interval.Duration {
+			return fmt.Errorf("Timeout can not be more than the Interval %v", *p.Name())
+		}
+		o := p.Options()
+		if o.Retries < 0 {
+			return fmt.Errorf("Retries can not be negative %v", *p.Name())
+		}
+		var delay time.Duration
+		if d := o.RetryDelay; d != nil {
+			if d.Cron != nil || d.Duration < 0 {
+				return fmt.Errorf("Retry delay needs to be a positive duration %v", *p.Name())
+			}
+			delay = d.Duration
+		}
+		// all the attempts, i.e (retries+1)*timeout + retries*retry_delay, need to fit in the interval.
+		if interval.Cron == nil && o.Retries > 0 {
+			attempt := timeout.Duration + delay
+			if n := time.Duration(o.Retries); n > interval.Duration/attempt || timeout.Duration+n*attempt > interval.Duration {
+				return fmt.Errorf("Retries can not take more than the Interval %v", *p.Name())
+			}
+		}
+	}
+	return checkDependencies(probes)
+}
+
+// checkDependencies checks that the probes depend on known probes, without cycles.
+func checkDependencies(probes []modules.Prober) error {
+	dependsOn := make(map[string][]string)
+	for _, p := range probes {
+		dependsOn[*p.Name()] = p.Options().DependsOn
+	}
+	for pn, parents := range dependsOn {
+		for _, parent := range parents {
+			if _, ok := dependsOn[parent]; !ok {
+				return fmt.Errorf("Probe %s depends on unknown probe %s", pn, parent)
+			}
+		}
+	}
+
+	// depth first search, the probes on the current path being visiting.
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	var visit func(pn string, path []string) error
+	visit = func(pn string, path []string) error {
+		path = append(path, pn)
+		switch marks[pn] {
+		case visiting:
+			return fmt.Errorf("Probe dependency cycle %s", strings.Join(path, " -> "))
+		case done:
+			return nil
+		}
+		marks[pn] = visiting
+		for _, parent := range dependsOn[pn] {
+			if err := visit(parent, path); err != nil {
+				return err
+			}
+		}
+		marks[pn] = done
+		return nil
+	}
+	for _, p := range probes {
+		if err := visit(*p.Name(), nil); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -42,6 +136,21 @@ func CheckProbeData(pd *modules.ProbeData) error {
 	return nil
 }
 
+// StopChannel returns a stop channel, as taken by the probe runs, which is closed once the given context is done, e.g
+// once the client of a request goes away. The returned function releases the channel once it is no longer needed.
+func StopChannel(ctx context.Context) (chan bool, func()) {
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopCh)
+		case <-doneCh:
+		}
+	}()
+	return stopCh, func() { close(doneCh) }
+}
+
 func HandleHomePage(w http.ResponseWriter, r *http.Request) {
 	err := templates.ExecuteTemplate(w, "indexPage", nil)
 	if err != nil {
@@ -50,17 +159,10 @@ func HandleHomePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// TODO: Return pure json instead of html.
-func HandleConfig(config []byte) http.HandlerFunc {
+// HandleConfig serves the config page of the given probes. See API.HandleConfig for the json form.
+func HandleConfig(probes []modules.Prober) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		probes, err := conf.SetupConfig(config)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		err = templates.ExecuteTemplate(w, "configPage", probes)
+		err := templates.ExecuteTemplate(w, "configPage", probes)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -68,14 +170,25 @@ func HandleConfig(config []byte) http.HandlerFunc {
 	}
 }
 
+// statusPage is the view of the status page for a request. The page itself fetches the probes from the json api.
+type statusPage struct {
+	Tmpl   TemplateParams
+	Types  []string // the probe types, to filter on.
+	States []string // the probe states, to filter on.
+}
+
 func HandleStatus(ps *ProbesStatus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		showParams := r.URL.Query().Get("showparams")
-		if showParams == "single_probe" {
-			ps.Tmpl.ProbeSingle = r.URL.Query().Get("probe_name")
+		page := statusPage{Tmpl: TemplateParams{ShowParams: "all"}, Types: conf.ProbeTypes, States: States}
+		if r.URL.Query().Get("showparams") == "single_probe" {
+			page.Tmpl.ShowParams = "single_probe"
+			page.Tmpl.ProbeSingle = r.URL.Query().Get("probe_name")
+			if !ps.hasProbe(page.Tmpl.ProbeSingle) {
+				http.Error(w, fmt.Sprintf("Unknown probe '%s'", page.Tmpl.ProbeSingle), http.StatusNotFound)
+				return
+			}
 		}
-		ps.Tmpl.ShowParams = showParams
-		err := templates.ExecuteTemplate(w, "statusPage", ps)
+		err := templates.ExecuteTemplate(w, "statusPage", page)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1,8 +1,19 @@
 package misc
 
 import (
+	"context"
+	"github.com/samitpal/goProbe/conf"
 	"github.com/samitpal/goProbe/modules"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCheckProbeConfig(t *testing.T) {
@@ -11,6 +22,68 @@ func TestCheckProbeConfig(t *testing.T) {
 	if err == nil {
 		t.Error("Expected an error to be returned")
 	}
+
+	for config, valid := range map[string]bool{
+		`"probe_interval": 60, "probe_timeout": 10`:                                       true,
+		`"probe_interval": "500ms", "probe_timeout": "250ms"`:                             true,
+		`"probe_interval": "5 * * * *", "probe_timeout": "5m"`:                            true,
+		`"probe_interval": "500ms", "probe_timeout": 1`:                                   false,
+		`"probe_interval": 0, "probe_timeout": 0`:                                         false,
+		`"probe_interval": "-1s", "probe_timeout": "1s"`:                                  false,
+		`"probe_interval": 60, "probe_timeout": "5 * * * *"`:                              false,
+		`"probe_interval": 60, "probe_timeout": 10, "retries": 2, "retry_delay": "500ms"`: true,
+		`"probe_interval": 60, "probe_timeout": 10, "retries": -1`:                        false,
+		`"probe_interval": 30, "probe_timeout": 10, "retries": 2`:                         true,
+		`"probe_interval": 30, "probe_timeout": 10, "retries": 2, "retry_delay": "1s"`:    false,
+		`"probe_interval": 60, "probe_timeout": 20, "retries": 1, "retry_delay": 20`:      true,
+		`"probe_interval": 60, "probe_timeout": 20, "retries": 1, "retry_delay": 21`:      false,
+		`"probe_interval": 60, "probe_timeout": 1, "retries": 100000000000`:               false,
+		`"probe_interval": "5 * * * *", "probe_timeout": "5m", "retries": 100`:            true,
+		`"probe_interval": 60, "probe_timeout": 10, "retry_delay": "5 * * * *"`:           false,
+	} {
+		probes, err := conf.SetupConfig([]byte(`[{"probe_type": "http", "probe_config": {"probe_name": "web", "probe_url": "http://example.com", ` + config + `}}]`))
+		if err != nil {
+			t.Fatalf("%s: Error: %v", config, err)
+		}
+		if err = CheckProbeConfig(probes); (err == nil) != valid {
+			t.Errorf("%s: Got: %v\n Want valid: %v", config, err, valid)
+		}
+	}
+
+	// the probe names can not contain a /, which would be taken as part of the api path.
+	probes, err := conf.SetupConfig([]byte(`[{"probe_type": "http", "probe_config": {"probe_name": "web/pause", "probe_url": "http://example.com"}}]`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = CheckProbeConfig(probes); err == nil {
+		t.Error("Expecting error for a probe name with a /, but test is passing")
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	tests := []struct {
+		dependsOn [3]string // of the probes a, b and c.
+		want      string    // the error, if any.
+	}{
+		{[3]string{``, `"a"`, `"a", "b"`}, ""},
+		{[3]string{``, `"d"`, ``}, "Probe b depends on unknown probe d"},
+		{[3]string{`"a"`, ``, ``}, "Probe dependency cycle a -> a"},
+		{[3]string{`"c"`, `"a"`, `"b"`}, "Probe dependency cycle a -> c -> b -> a"},
+	}
+	for _, tt := range tests {
+		var config []string
+		for i, pn := range []string{"a", "b", "c"} {
+			config = append(config, `{"probe_type": "http", "probe_config": {"probe_name": "`+pn+`", "probe_url": "http://`+pn+`", "depends_on": [`+tt.dependsOn[i]+`]}}`)
+		}
+		probes, err := conf.SetupConfig([]byte("[" + strings.Join(config, ",") + "]"))
+		if err != nil {
+			t.Fatalf("%v: Error: %v", tt.dependsOn, err)
+		}
+		err = CheckProbeConfig(probes)
+		if (err == nil) != (tt.want == "") || (err != nil && err.Error() != tt.want) {
+			t.Errorf("%v: Got: %v\n Want: %s", tt.dependsOn, err, tt.want)
+		}
+	}
 }
 
 func TestCheckProbeData(t *testing.T) {
@@ -51,3 +124,77 @@ func TestCheckProbeData(t *testing.T) {
 
 	}
 }
+
+func TestStopChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopCh, release := StopChannel(ctx)
+	defer release()
+	select {
+	case <-stopCh:
+		t.Fatal("Stop channel closed before the context is done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-stopCh:
+	case <-time.After(time.Second):
+		t.Error("Stop channel not closed once the context is done")
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	ps := NewProbesStatus([]string{"probe1", "probe2"})
+
+	// concurrent viewers of different pages do not see each other's page.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, path := range []string{"/status", "/status?showparams=single_probe&probe_name=probe2"} {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				HandleStatus(ps)(w, httptest.NewRequest("GET", path, nil))
+				single := strings.Contains(w.Body.String(), `id="detail"`) && strings.Contains(w.Body.String(), `var probe = "probe2"`)
+				if w.Code != http.StatusOK || single != strings.Contains(path, "single_probe") {
+					t.Errorf("%s: Got: %d, single probe page %v", path, w.Code, single)
+				}
+			}(path)
+		}
+	}
+	wg.Wait()
+
+	w := httptest.NewRecorder()
+	HandleStatus(ps)(w, httptest.NewRequest("GET", "/status?showparams=single_probe&probe_name=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Got: %d\n Want: %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetupTemplates(t *testing.T) {
+	defer func(t *template.Template) { templates = t }(templates)
+
+	dir, err := ioutil.TempDir("", "goprobe_templates")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{define "indexPage"}}custom{{end}}`), 0644); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = SetupTemplates(filepath.Join(dir, "*.html")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	w := httptest.NewRecorder()
+	HandleHomePage(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "custom" {
+		t.Errorf("Got: %s\n Want: custom", w.Body.String())
+	}
+	// the templates not overridden are still there.
+	if templates.Lookup("statusPage") == nil {
+		t.Error("Expecting the embedded statusPage template to be kept")
+	}
+
+	if err = SetupTemplates(filepath.Join(dir, "none*.html")); err == nil {
+		t.Error("Expecting error due to no matching template file, but test is passing")
+	}
+}
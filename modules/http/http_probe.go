@@ -8,20 +8,24 @@ import (
 	"github.com/samitpal/goProbe/modules"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 )
 
 type httpProbe struct {
-	ProbeName                 *string       `json:"probe_name"`
-	ProbeURL                  *string       `json:"probe_url"`
-	ProbeHttpMethod           *string       `json:"probe_http_method"`
-	ProbeAction               *string       `json:"probe_action"`
-	ProbeMatchString          *string       `json:"probe_match_string"`            // a regulat expression.
-	ProbeHttpHeaders          *probeHeaders `json:"probe_http_headers"`            // request headers.
-	ProbeSSLCertExpiresInDays *int          `json:"probe_sslcert_expires_in_days"` // ssl cert expire within these many days.
-	ProbeInterval             *int          `json:"probe_interval"`
-	ProbeTimeout              *int          `json:"probe_timeout"`
+	ProbeName                 *string           `json:"probe_name"`
+	ProbeURL                  *string           `json:"probe_url"`
+	ProbeHttpMethod           *string           `json:"probe_http_method"`
+	ProbeAction               *string           `json:"probe_action"`
+	ProbeMatchString          *string           `json:"probe_match_string"`            // a regulat expression.
+	ProbeHttpHeaders          *probeHeaders     `json:"probe_http_headers"`            // request headers.
+	ProbeSSLCertExpiresInDays *int              `json:"probe_sslcert_expires_in_days"` // ssl cert expire within these many days.
+	ProbeInterval             *modules.Interval `json:"probe_interval"`                // seconds, a duration like 500ms or a cron expression.
+	ProbeTimeout              *modules.Interval `json:"probe_timeout"`                 // seconds or a duration.
+
+	modules.ProbeOptions // the options common to all modules, e.g probe_labels.
 }
 
 type probeHeaders struct {
@@ -75,12 +79,10 @@ func (p *httpProbe) setDefaults() {
 		}
 	}
 	if p.ProbeTimeout == nil {
-		i := 40
-		p.ProbeTimeout = &i
+		p.ProbeTimeout = modules.Seconds(40)
 	}
 	if p.ProbeInterval == nil {
-		i := 60
-		p.ProbeInterval = &i
+		p.ProbeInterval = modules.Seconds(60)
 	}
 }
 
@@ -121,7 +123,7 @@ func (p *httpProbe) Prepare() error {
 func (p httpProbe) Run(respCh chan<- *modules.ProbeData, errCh chan<- error) {
 	// Run the http probe
 	startTime := time.Now().UnixNano()
-	client := &http.Client{Timeout: time.Duration(*p.ProbeTimeout) * time.Second}
+	client := &http.Client{Timeout: p.ProbeTimeout.Duration}
 
 	req, err := http.NewRequest(*p.ProbeHttpMethod, *p.ProbeURL, nil)
 	if err != nil {
@@ -191,15 +193,39 @@ func (p httpProbe) Run(respCh chan<- *modules.ProbeData, errCh chan<- error) {
 	return
 }
 
+// SetTarget sets the probe url. Targets without a scheme are probed over plain http.
+func (p *httpProbe) SetTarget(target string) error {
+	if target == "" {
+		return errors.New("Empty target")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	p.ProbeURL = &target
+	return nil
+}
+
+// Target returns the host of the probe url.
+func (p *httpProbe) Target() string {
+	if p.ProbeURL == nil {
+		return ""
+	}
+	u, err := url.Parse(*p.ProbeURL)
+	if err != nil || u.Host == "" {
+		return *p.ProbeURL
+	}
+	return u.Hostname()
+}
+
 func (p httpProbe) Name() *string {
 	return p.ProbeName
 }
 
-func (p httpProbe) RunIntervalSecs() *int {
+func (p httpProbe) RunInterval() *modules.Interval {
 	return p.ProbeInterval
 }
 
-func (p httpProbe) TimeoutSecs() *int {
+func (p httpProbe) Timeout() *modules.Interval {
 	return p.ProbeTimeout
 }
 
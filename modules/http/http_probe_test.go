@@ -55,3 +55,20 @@ func TestCheckConfig(t *testing.T) {
 		t.Errorf("Probe http action is invalid. Test expected to fail but is passing")
 	}
 }
+
+func TestTarget(t *testing.T) {
+	for url, want := range map[string]string{
+		"http://example.com/health":      "example.com",
+		"https://example.com:8443/":      "example.com",
+		"http://[2001:db8::1]:8080/path": "2001:db8::1",
+	} {
+		p := NewHttpProbe()
+		p.ProbeURL = &url
+		if got := p.Target(); got != want {
+			t.Errorf("%s: Got: %s\n Want: %s", url, got, want)
+		}
+	}
+	if got := NewHttpProbe().Target(); got != "" {
+		t.Errorf("Got: %s\n Want an empty target without url", got)
+	}
+}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samitpal/goProbe/cron"
+	"strconv"
+	"time"
+)
+
+// Interval is the value of the probe_interval and probe_timeout config fields. It is either a number of seconds, e.g
+// 60, a duration, e.g "500ms" or "1m30s", or, for probe_interval only, a cron expression, e.g "5 * * * *" for minute 5
+// of every hour.
+type Interval struct {
+	Duration time.Duration  // zero for a cron expression.
+	Cron     *cron.Schedule // nil unless a cron expression.
+}
+
+// Seconds returns the interval of the given number of seconds, e.g to set the default of a config field.
+func Seconds(n int) *Interval {
+	return &Interval{Duration: time.Duration(n) * time.Second}
+}
+
+// UnmarshalJSON parses a number of seconds, a duration or a cron expression.
+func (i *Interval) UnmarshalJSON(b []byte) error {
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		*i = *Seconds(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("Invalid interval %s, expecting a number of seconds or a string", b)
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		*i = *Seconds(n)
+		return nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		*i = Interval{Duration: d}
+		return nil
+	}
+	c, err := cron.Parse(s)
+	if err != nil {
+		return fmt.Errorf("Invalid interval '%s', expecting a number of seconds, a duration like 1m30s or a cron expression: %v", s, err)
+	}
+	*i = Interval{Cron: c}
+	return nil
+}
+
+// MarshalJSON returns the interval as it is set in the config, the whole seconds being a number as before.
+func (i Interval) MarshalJSON() ([]byte, error) {
+	if i.Cron == nil && i.Duration%time.Second == 0 {
+		return json.Marshal(int(i.Duration / time.Second))
+	}
+	return json.Marshal(i.String())
+}
+
+// String returns the cron expression or the duration, e.g 1m30s.
+func (i Interval) String() string {
+	if i.Cron != nil {
+		return i.Cron.String()
+	}
+	return i.Duration.String()
+}
@@ -0,0 +1,42 @@
+package modules
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		config   string
+		duration time.Duration
+		cron     string
+		json     string // the marshalled interval.
+	}{
+		{`60`, time.Minute, "", `60`},
+		{`"60"`, time.Minute, "", `60`},
+		{`"500ms"`, 500 * time.Millisecond, "", `"500ms"`},
+		{`"1m30s"`, 90 * time.Second, "", `90`},
+		{`"5 * * * *"`, 0, "5 * * * *", `"5 * * * *"`},
+		{`"@hourly"`, 0, "@hourly", `"@hourly"`},
+	}
+	for _, tt := range tests {
+		var i Interval
+		if err := json.Unmarshal([]byte(tt.config), &i); err != nil {
+			t.Errorf("%s: Error: %v", tt.config, err)
+			continue
+		}
+		if i.Duration != tt.duration || (i.Cron == nil) != (tt.cron == "") || (i.Cron != nil && i.Cron.String() != tt.cron) {
+			t.Errorf("%s: Got: %v\n Want: %v%s", tt.config, i, tt.duration, tt.cron)
+		}
+		if b, err := json.Marshal(i); err != nil || string(b) != tt.json {
+			t.Errorf("%s: Got: %s, %v\n Want: %s", tt.config, b, err, tt.json)
+		}
+	}
+	for _, config := range []string{`1.5`, `"1 minute"`, `"* * *"`, `true`} {
+		var i Interval
+		if err := json.Unmarshal([]byte(config), &i); err == nil {
+			t.Errorf("%s: Expecting error, but test is passing", config)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package ping_port
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/samitpal/goProbe/modules"
 	"net"
@@ -13,12 +14,14 @@ import (
 )
 
 type pingPortProbe struct {
-	ProbeName     *string `json:"probe_name"`
-	ProbeInterval *int    `json:"probe_interval"`
-	ProbeTimeout  *int    `json:"probe_timeout"`
-	ProbeHostName *string `json:"probe_host_name"`
-	ProbeHostPort *int    `json:"probe_host_port"`
-	ProbeNetwork  *string `json:"probe_network"` //tcp or udp.
+	ProbeName     *string           `json:"probe_name"`
+	ProbeInterval *modules.Interval `json:"probe_interval"` // seconds, a duration like 500ms or a cron expression.
+	ProbeTimeout  *modules.Interval `json:"probe_timeout"`  // seconds or a duration.
+	ProbeHostName *string           `json:"probe_host_name"`
+	ProbeHostPort *int              `json:"probe_host_port"`
+	ProbeNetwork  *string           `json:"probe_network"` //tcp or udp.
+
+	modules.ProbeOptions // the options common to all modules, e.g probe_labels.
 }
 
 func NewPingPortProbe() *pingPortProbe {
@@ -52,12 +55,10 @@ func (p *pingPortProbe) setDefaults() {
 		p.ProbeNetwork = &network
 	}
 	if p.ProbeTimeout == nil {
-		timeout := 10
-		p.ProbeTimeout = &timeout // since we don't send/receive any data, setting it to low value.
+		p.ProbeTimeout = modules.Seconds(10) // since we don't send/receive any data, setting it to low value.
 	}
 	if p.ProbeInterval == nil {
-		interval := 60
-		p.ProbeInterval = &interval
+		p.ProbeInterval = modules.Seconds(60)
 	}
 }
 
@@ -70,6 +71,37 @@ func (p *pingPortProbe) Prepare() error {
 	return nil
 }
 
+// SetTarget sets the target host and port from a host:port string. The port can be left out if the config
+// already sets probe_host_port.
+func (p *pingPortProbe) SetTarget(target string) error {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		if p.ProbeHostPort == nil {
+			return err
+		}
+		host = target
+	} else {
+		pn, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("Invalid port in target %s: %v", target, err)
+		}
+		p.ProbeHostPort = &pn
+	}
+	if host == "" {
+		return errors.New("Empty target host")
+	}
+	p.ProbeHostName = &host
+	return nil
+}
+
+// Target returns the probed host.
+func (p *pingPortProbe) Target() string {
+	if p.ProbeHostName == nil {
+		return ""
+	}
+	return *p.ProbeHostName
+}
+
 func (p *pingPortProbe) Name() *string {
 	return p.ProbeName
 }
@@ -77,9 +109,13 @@ func (p *pingPortProbe) Name() *string {
 func (p *pingPortProbe) Run(respCh chan<- *modules.ProbeData, errCh chan<- error) {
 	startTime := time.Now().UnixNano()
 	var isUp float64
-	// we set timeout less by 1 sec since we want a slightly higher timeout for the caller (core).
-	timeout := *p.ProbeTimeout - 1
-	conn, err := net.DialTimeout(*p.ProbeNetwork, *p.ProbeHostName+":"+strconv.Itoa(*p.ProbeHostPort), time.Duration(timeout)*time.Second)
+	// we set timeout less by 1 sec, or by half for the short timeouts, since we want a slightly higher timeout for the
+	// caller (core).
+	timeout := p.ProbeTimeout.Duration - time.Second
+	if timeout < p.ProbeTimeout.Duration/2 {
+		timeout = p.ProbeTimeout.Duration / 2
+	}
+	conn, err := net.DialTimeout(*p.ProbeNetwork, *p.ProbeHostName+":"+strconv.Itoa(*p.ProbeHostPort), timeout)
 
 	if conn != nil {
 		defer conn.Close()
@@ -102,11 +138,11 @@ func (p *pingPortProbe) Run(respCh chan<- *modules.ProbeData, errCh chan<- error
 	}
 	return
 }
-func (p *pingPortProbe) TimeoutSecs() *int {
+func (p *pingPortProbe) Timeout() *modules.Interval {
 	return p.ProbeTimeout
 }
 
-func (p *pingPortProbe) RunIntervalSecs() *int {
+func (p *pingPortProbe) RunInterval() *modules.Interval {
 	return p.ProbeInterval
 }
 
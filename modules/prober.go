@@ -20,6 +20,33 @@ type ProbeData struct {
 	Payload     *[]byte     // Optional.
 }
 
+// ProbeOptions holds the probe config fields which are common to all the probe modules and are handled by the core
+// rather than by the module itself. A module gets these by embedding ProbeOptions in its config struct.
+type ProbeOptions struct {
+	ProbeLabels map[string]string `json:"probe_labels,omitempty"` // Optional. Passed on to the metric push providers.
+
+	// Optional. These set how the up/down state of the probe follows the results of its runs, see misc.ProbeState.
+	// The zero values mean the defaults set by the command line flags.
+	FailuresBeforeDown int    `json:"failures_before_down,omitempty"`
+	SuccessesBeforeUp  int    `json:"successes_before_up,omitempty"`
+	FlapWindow         string `json:"flap_window,omitempty"` // e.g 30m.
+	FlapThreshold      int    `json:"flap_threshold,omitempty"`
+
+	// Optional. A failed run, i.e failure, error or timeout, is retried up to Retries times, RetryDelay apart, before
+	// the run counts as failed.
+	Retries    int       `json:"retries,omitempty"`
+	RetryDelay *Interval `json:"retry_delay,omitempty"` // seconds or a duration, e.g 500ms. No delay if not set.
+
+	// Optional. The names of the probes this probe depends on, e.g the probe of a router in front of the probed host.
+	// The probe is unreachable rather than down, and not notified, while one of them is down or unreachable.
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// Options returns the common probe options. It is promoted to the modules embedding ProbeOptions.
+func (o *ProbeOptions) Options() *ProbeOptions {
+	return o
+}
+
 // Prober is the interface that a probe module needs to implement.
 type Prober interface {
 	// Prepare is used to set up the probe module. Use it to do custom initialization.
@@ -35,12 +62,25 @@ type Prober interface {
 	// Name returns the name of the probe.
 	Name() *string
 
-	// TimeoutSecs returns the timeout for a given probe.
-	TimeoutSecs() *int
+	// Timeout returns the timeout for a given probe. It is a duration, never a cron expression.
+	Timeout() *Interval
 
-	// RunIntervalSecs returns the frequency of the probe.
-	RunIntervalSecs() *int
+	// RunInterval returns the frequency of the probe, either a duration or a cron expression.
+	RunInterval() *Interval
 
 	// RetConfig returns the config values of the probe module. This will be used in the http ui.
 	RetConfig() string
+
+	// Options returns the options common to all modules. It is implemented by embedding ProbeOptions.
+	Options() *ProbeOptions
+}
+
+// Targeter is implemented by the probe modules which can be run against a target given at run time rather than
+// in the config, e.g by the /probe http handler.
+type Targeter interface {
+	// SetTarget sets the target of the probe, e.g an url for the http module. It is called before Prepare().
+	SetTarget(string) error
+
+	// Target returns the host the probe is run against, e.g for the per target concurrency limit.
+	Target() string
 }
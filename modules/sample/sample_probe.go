@@ -7,10 +7,12 @@ import (
 )
 
 type TestProbe struct {
-	ProbeName     *string `json:"probe_name"`
-	ProbeInterval *int    `json:"probe_interval"`
-	ProbeTimeout  *int    `json:"probe_timeout"`
-	ProbeMyConfig *string `json:"probe_my_config"` // this config field is specific to this module.
+	ProbeName     *string           `json:"probe_name"`
+	ProbeInterval *modules.Interval `json:"probe_interval"`
+	ProbeTimeout  *modules.Interval `json:"probe_timeout"`
+	ProbeMyConfig *string           `json:"probe_my_config"` // this config field is specific to this module.
+
+	modules.ProbeOptions // the options common to all modules, e.g probe_labels.
 }
 
 func (t *TestProbe) Prepare() error {
@@ -35,11 +37,11 @@ func (t *TestProbe) Run(respCh chan<- *modules.ProbeData, errCh chan<- error) {
 	}
 	return
 }
-func (t *TestProbe) TimeoutSecs() *int {
+func (t *TestProbe) Timeout() *modules.Interval {
 	return t.ProbeTimeout
 }
 
-func (t *TestProbe) RunIntervalSecs() *int {
+func (t *TestProbe) RunInterval() *modules.Interval {
 	return t.ProbeInterval
 }
 
@@ -0,0 +1,297 @@
+// Package notify sends notifications when a probe goes down, starts flapping or comes back up, e.g to a webhook, slack
+// or the prometheus alertmanager.
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/samitpal/goProbe/misc"
+	"path"
+	"sync"
+	"time"
+)
+
+/* Example json config of the notify section.
+"notify": {
+    "repeat_interval": "1h",
+    "targets": [
+        {
+            "type": "slack",
+            "url": "https://hooks.slack.com/services/T000/B000/XXXX",
+            "exclude": ["*_staging"]
+        },
+        {
+            "type": "alertmanager",
+            "url": "http://alertmanager.example.com:9093"
+        },
+        {
+            "type": "webhook",
+            "name": "pager",
+            "url": "https://pager.example.com/events",
+            "headers": {"Authorization": "Bearer secret"},
+            "template": "{\"probe\": \"{{.ProbeName}}\", \"state\": \"{{.State}}\"}"
+        }
+    ]
+}
+*/
+
+// Config is the notify section of the config. The probes are notified of as their state changes, see
+// misc.ProbeState.
+type Config struct {
+	RepeatInterval string         `json:"repeat_interval"` // optional, e.g 1h. Notify again while a probe stays down or flapping.
+	Targets        []TargetConfig `json:"targets"`
+}
+
+// Event is a notification. It is the data of the notification templates.
+type Event struct {
+	ProbeName     string            `json:"probe_name"`
+	Labels        map[string]string `json:"labels,omitempty"` // the probe labels.
+	State         string            `json:"state"`            // up, down or flapping.
+	PreviousState string            `json:"previous_state"`
+	Result        string            `json:"result"`   // the result of the last probe run, e.g timeout.
+	Since         time.Time         `json:"since"`    // when the probe went to its current state.
+	Time          time.Time         `json:"time"`     // when the event was sent.
+	Failures      int               `json:"failures"` // consecutive failed runs.
+	Reminder      bool              `json:"reminder"` // whether the probe was already notified as down.
+}
+
+// probeState is what the notifier knows of a probe.
+type probeState struct {
+	notified string            // the state the targets were last notified of, see Observe.
+	status   *misc.ProbeStatus // the status of the probe after its last run observed.
+	result   string
+	labels   map[string]string
+}
+
+// Notifier notifies the targets of the state changes of the probes, the state being the one of misc.ProbesStatus.
+// The methods of a nil Notifier do nothing, so that it can be used when notifications are not configured.
+type Notifier struct {
+	targets []*target
+	muted   func(pn string, now time.Time) bool // see SetMuted.
+
+	lock     sync.Mutex
+	states   map[string]*probeState
+	lastSent map[*target]map[string]time.Time // the last notification of a probe to a target.
+
+	events chan sendReq
+	stopCh chan bool
+	doneCh chan bool
+}
+
+// sendReq is an event to be sent to a target.
+type sendReq struct {
+	t *target
+	e Event
+}
+
+// SetupNotifier sets up the notifier of the given notify config section. It returns nil if the section is empty.
+func SetupNotifier(config json.RawMessage) (*Notifier, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+	var c Config
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	return NewNotifier(c)
+}
+
+// NewNotifier returns a notifier of the given config.
+func NewNotifier(c Config) (*Notifier, error) {
+	var repeat time.Duration
+	if c.RepeatInterval != "" {
+		var err error
+		if repeat, err = time.ParseDuration(c.RepeatInterval); err != nil {
+			return nil, fmt.Errorf("Notify repeat interval: %v", err)
+		}
+	}
+	if len(c.Targets) == 0 {
+		return nil, errors.New("No notify targets found")
+	}
+
+	n := &Notifier{
+		states:   make(map[string]*probeState),
+		lastSent: make(map[*target]map[string]time.Time),
+		events:   make(chan sendReq, 100),
+		stopCh:   make(chan bool),
+		doneCh:   make(chan bool),
+	}
+	names := make(map[string]bool)
+	for _, tc := range c.Targets {
+		if tc.Name == "" {
+			tc.Name = tc.Type
+		}
+		if names[tc.Name] {
+			return nil, fmt.Errorf("Duplicate notify target name '%s'. Set a unique name for each target.", tc.Name)
+		}
+		names[tc.Name] = true
+		t, err := newTarget(tc, repeat)
+		if err != nil {
+			return nil, fmt.Errorf("Notify target '%s': %v", tc.Name, err)
+		}
+		n.targets = append(n.targets, t)
+		n.lastSent[t] = make(map[string]time.Time)
+	}
+	return n, nil
+}
+
+// SetMuted sets the function telling whether a probe is muted, e.g silenced. The state changes of a muted probe are
+// not notified and it is not reminded. Once it is unmuted, the targets are notified if its state is not the one they
+// were last notified of. It should be called before Start.
+func (n *Notifier) SetMuted(muted func(pn string, now time.Time) bool) {
+	if n == nil {
+		return
+	}
+	n.muted = muted
+}
+
+// Start starts the goroutine sending the notifications and the reminders.
+func (n *Notifier) Start() {
+	if n == nil {
+		return
+	}
+	go n.loop()
+}
+
+// Stop stops the notifier. The notifications not sent yet are dropped.
+func (n *Notifier) Stop() {
+	if n == nil {
+		return
+	}
+	close(n.stopCh)
+	<-n.doneCh
+}
+
+// Observe notifies the targets if the state of the given probe after a run, as set in the given status, is not the
+// one they were last notified of. prev is the state of the probe before the run, which the targets are taken to
+// know of the first time the probe is observed, e.g after a restart. A probe which is unreachable or whose state is
+// still unknown is not notified.
+func (n *Notifier) Observe(pn string, labels map[string]string, result string, prev string, status *misc.ProbeStatus) {
+	if n == nil {
+		return
+	}
+	now := time.Unix(0, status.ProbeEndTime)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	s, ok := n.states[pn]
+	if !ok {
+		s = &probeState{notified: prev}
+		if prev == misc.StateUnreachable {
+			s.notified = misc.StateUnknown // whether the targets were notified of the probe as down is not known.
+		}
+		n.states[pn] = s
+	}
+	s.status, s.result, s.labels = status, result, labels
+	if n.isMuted(pn, now) {
+		return
+	}
+	if status.State == s.notified || status.State == misc.StateUnknown || status.State == misc.StateUnreachable {
+		return
+	}
+	prev, s.notified = s.notified, status.State
+	if prev == misc.StateUnknown && status.State == misc.StateUp {
+		return // nothing has changed as far as the targets know.
+	}
+	glog.Infof("Notifying probe %s as %s, was %s.", pn, status.State, prev)
+	n.notify(n.event(pn, s, prev, now, false), now)
+}
+
+func (n *Notifier) isMuted(pn string, now time.Time) bool {
+	return n.muted != nil && n.muted(pn, now)
+}
+
+// event returns the event of the given probe state. Needs the lock to be held.
+func (n *Notifier) event(pn string, s *probeState, prev string, now time.Time, reminder bool) Event {
+	return Event{
+		ProbeName:     pn,
+		Labels:        s.labels,
+		State:         s.notified,
+		PreviousState: prev,
+		Result:        s.result,
+		Since:         time.Unix(0, s.status.StateSince),
+		Time:          now,
+		Failures:      s.status.Failures,
+		Reminder:      reminder,
+	}
+}
+
+// notify queues the event for the targets matching the probe. Needs the lock to be held.
+func (n *Notifier) notify(e Event, now time.Time) {
+	for _, t := range n.targets {
+		if !t.matches(e.ProbeName) {
+			continue
+		}
+		n.lastSent[t][e.ProbeName] = now
+		select {
+		case n.events <- sendReq{t, e}:
+		default:
+			glog.Errorf("Notification queue is full, dropping the %s notification of probe %s to %s.", e.State, e.ProbeName, t.c.Name)
+		}
+	}
+}
+
+// remind notifies the targets again of the probes which are still down or flapping after the repeat interval of the
+// target.
+func (n *Notifier) remind(now time.Time) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for pn, s := range n.states {
+		if (s.notified != misc.StateDown && s.notified != misc.StateFlapping) || s.status == nil || n.isMuted(pn, now) {
+			continue
+		}
+		for _, t := range n.targets {
+			if t.repeat <= 0 || !t.matches(pn) || now.Sub(n.lastSent[t][pn]) < t.repeat {
+				continue
+			}
+			n.lastSent[t][pn] = now
+			select {
+			case n.events <- sendReq{t, n.event(pn, s, s.notified, now, true)}:
+			default:
+				glog.Errorf("Notification queue is full, dropping the reminder of probe %s to %s.", pn, t.c.Name)
+			}
+		}
+	}
+}
+
+// loop sends the queued notifications and checks for reminders.
+func (n *Notifier) loop() {
+	defer close(n.doneCh)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-n.events:
+			if err := r.t.send(r.e); err != nil {
+				glog.Errorf("Failed to send the %s notification of probe %s to %s: %v", r.e.State, r.e.ProbeName, r.t.c.Name, err)
+			}
+		case now := <-ticker.C:
+			n.remind(now)
+		case <-n.stopCh:
+			return
+		}
+	}
+}
+
+// matchGlobs tells whether the probe name matches one of the include globs, if any, and none of the exclude globs.
+func matchGlobs(pn string, include, exclude []string) bool {
+	included := len(include) == 0
+	for _, g := range include {
+		if ok, _ := path.Match(g, pn); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, g := range exclude {
+		if ok, _ := path.Match(g, pn); ok {
+			return false
+		}
+	}
+	return true
+}
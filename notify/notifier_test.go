@@ -0,0 +1,290 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/misc"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain returns the events queued by the notifier.
+func drain(n *Notifier) []Event {
+	var events []Event
+	for {
+		select {
+		case r := <-n.events:
+			events = append(events, r.e)
+		default:
+			return events
+		}
+	}
+}
+
+// probe is a probe whose runs are observed by a notifier, its state following the results of the runs as in
+// misc.ProbesStatus.
+type probe struct {
+	name  string
+	c     misc.StateConfig
+	state *misc.ProbeState
+}
+
+// run observes a run of the probe with the given result and returns the events queued by the notifier.
+func (p *probe) run(n *Notifier, result string, now time.Time) []Event {
+	if p.state == nil {
+		p.state = misc.NewProbeState(now)
+	}
+	prev := p.state.Current()
+	p.state.Update(p.c, result == metric_export.ResultSuccess, now)
+	status := &misc.ProbeStatus{ProbeEndTime: now.UnixNano(), State: p.state.Current(), StateSince: p.state.Since.UnixNano(), Failures: p.state.Failures}
+	n.Observe(p.name, map[string]string{"env": "prod"}, result, prev, status)
+	return drain(n)
+}
+
+func TestObserve(t *testing.T) {
+	n, err := NewNotifier(Config{Targets: []TargetConfig{{Type: "webhook", URL: "http://localhost/hook"}}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	p := &probe{name: "probe1", c: misc.StateConfig{FailuresBeforeDown: 2, SuccessesBeforeUp: 2, FlapWindow: time.Hour, FlapThreshold: 3}}
+	now := time.Unix(1450000000, 0)
+	tests := []struct {
+		result string
+		want   string // the state of the event, if any.
+	}{
+		{metric_export.ResultSuccess, ""},
+		{metric_export.ResultTimeout, ""},
+		{metric_export.ResultTimeout, misc.StateDown},
+		{metric_export.ResultError, ""},
+		{metric_export.ResultSuccess, ""},
+		{metric_export.ResultFailure, ""},
+		{metric_export.ResultSuccess, ""},
+		{metric_export.ResultSuccess, misc.StateUp},
+		{metric_export.ResultSuccess, ""},
+		{metric_export.ResultFailure, ""},
+		{metric_export.ResultFailure, misc.StateFlapping},
+		{metric_export.ResultFailure, ""},
+	}
+	for i, tt := range tests {
+		now = now.Add(time.Minute)
+		events := p.run(n, tt.result, now)
+		if tt.want == "" {
+			if len(events) != 0 {
+				t.Errorf("Run %d: Got events %v\n Want none", i, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].State != tt.want {
+			t.Errorf("Run %d: Got events %v\n Want a %s event", i, events, tt.want)
+			continue
+		}
+		if events[0].ProbeName != "probe1" || events[0].Labels["env"] != "prod" || !events[0].Since.Equal(now) {
+			t.Errorf("Run %d: Got event %+v", i, events[0])
+		}
+	}
+
+	// a probe down before a restart is notified once it is back up.
+	n, _ = NewNotifier(Config{Targets: []TargetConfig{{Type: "webhook", URL: "http://localhost/hook"}}})
+	n.Observe("probe2", nil, metric_export.ResultSuccess, misc.StateDown, &misc.ProbeStatus{ProbeEndTime: now.UnixNano(), State: misc.StateUp, StateSince: now.UnixNano()})
+	if events := drain(n); len(events) != 1 || events[0].State != misc.StateUp || events[0].PreviousState != misc.StateDown {
+		t.Errorf("Got events %v\n Want an up event", events)
+	}
+
+	// an unreachable probe is not notified.
+	n.Observe("probe3", nil, metric_export.ResultTimeout, misc.StateUp, &misc.ProbeStatus{ProbeEndTime: now.UnixNano(), State: misc.StateUnreachable, StateSince: now.UnixNano()})
+	if events := drain(n); len(events) != 0 {
+		t.Errorf("Got events %v\n Want none", events)
+	}
+}
+
+func TestRemind(t *testing.T) {
+	n, err := NewNotifier(Config{
+		RepeatInterval: "1h",
+		Targets: []TargetConfig{
+			{Type: "webhook", URL: "http://localhost/hook"},
+			{Type: "slack", URL: "http://localhost/slack", RepeatInterval: "10m", Exclude: []string{"probe2"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	now := time.Unix(1450000000, 0)
+	p1, p2 := &probe{name: "probe1"}, &probe{name: "probe2"}
+	if events := append(p1.run(n, metric_export.ResultError, now), p2.run(n, metric_export.ResultError, now)...); len(events) != 3 {
+		t.Fatalf("Got events %v\n Want 3 down events", events)
+	}
+
+	n.remind(now.Add(30 * time.Minute))
+	events := drain(n)
+	if len(events) != 1 || events[0].ProbeName != "probe1" || !events[0].Reminder {
+		t.Errorf("Got events %v\n Want a reminder of probe1 to slack", events)
+	}
+	n.remind(now.Add(61 * time.Minute))
+	if events := drain(n); len(events) != 3 {
+		t.Errorf("Got events %v\n Want 3 reminders", events)
+	}
+
+	// no more reminders once the probe is up.
+	p1.run(n, metric_export.ResultSuccess, now.Add(62*time.Minute))
+	p2.run(n, metric_export.ResultSuccess, now.Add(62*time.Minute))
+	n.remind(now.Add(3 * time.Hour))
+	if events := drain(n); len(events) != 0 {
+		t.Errorf("Got events %v\n Want none", events)
+	}
+}
+
+func TestMuted(t *testing.T) {
+	n, err := NewNotifier(Config{RepeatInterval: "1h", Targets: []TargetConfig{{Type: "webhook", URL: "http://localhost/hook"}}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	now := time.Unix(1450000000, 0)
+	until := now.Add(2 * time.Hour)
+	n.SetMuted(func(pn string, t time.Time) bool { return pn == "probe1" && t.Before(until) })
+
+	p1, p2 := &probe{name: "probe1"}, &probe{name: "probe2"}
+	p1.run(n, metric_export.ResultSuccess, now.Add(-time.Minute))
+	if events := append(p1.run(n, metric_export.ResultError, now), p2.run(n, metric_export.ResultError, now)...); len(events) != 1 || events[0].ProbeName != "probe2" {
+		t.Errorf("Got events %v\n Want a down event of probe2", events)
+	}
+	n.remind(now.Add(90 * time.Minute))
+	if events := drain(n); len(events) != 1 || events[0].ProbeName != "probe2" {
+		t.Errorf("Got events %v\n Want a reminder of probe2", events)
+	}
+
+	// the probe still down once unmuted is notified, though its state has not changed since the muted run.
+	if events := p1.run(n, metric_export.ResultError, until); len(events) != 1 || events[0].ProbeName != "probe1" || events[0].State != misc.StateDown {
+		t.Errorf("Got events %v\n Want a down event of probe1", events)
+	}
+}
+
+func TestTargetSend(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	since := time.Unix(1450000000, 0)
+	down := Event{ProbeName: "probe1", Labels: map[string]string{"env": "prod"}, State: misc.StateDown, PreviousState: misc.StateUp, Result: metric_export.ResultTimeout, Since: since, Time: since, Failures: 3}
+
+	// webhook without a template gets the event as json.
+	tg, err := newTarget(TargetConfig{Type: "webhook", URL: ts.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer secret"}}, 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = tg.send(down); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var e Event
+	if err = json.Unmarshal(gotBody, &e); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if gotPath != "/hook" || gotAuth != "Bearer secret" || e.ProbeName != "probe1" || e.State != misc.StateDown || e.Failures != 3 {
+		t.Errorf("Got: %s, %s, %+v", gotPath, gotAuth, e)
+	}
+
+	// webhook with a template.
+	tg, err = newTarget(TargetConfig{Type: "webhook", URL: ts.URL, Template: `{"probe": "{{.ProbeName}}", "state": "{{.State}}"}`}, 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = tg.send(down); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if want := `{"probe": "probe1", "state": "down"}`; string(gotBody) != want {
+		t.Errorf("Got: %s\n Want: %s", gotBody, want)
+	}
+
+	// slack.
+	tg, err = newTarget(TargetConfig{Type: "slack", URL: ts.URL}, 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = tg.send(down); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var slack map[string]string
+	if err = json.Unmarshal(gotBody, &slack); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.HasPrefix(slack["text"], "DOWN: probe probe1 (timeout, 3 consecutive failures) since") {
+		t.Errorf("Got: %v", slack)
+	}
+	flapping := down
+	flapping.State, flapping.Reminder = misc.StateFlapping, true
+	if err = tg.send(flapping); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	slack = nil
+	if err = json.Unmarshal(gotBody, &slack); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.HasPrefix(slack["text"], "Still FLAPPING: probe probe1 since") {
+		t.Errorf("Got: %v", slack)
+	}
+
+	// alertmanager, firing and then resolved.
+	tg, err = newTarget(TargetConfig{Type: "alertmanager", URL: ts.URL + "/"}, 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if tg.repeat != time.Minute {
+		t.Errorf("Got repeat interval: %v\n Want: 1m", tg.repeat)
+	}
+	var alerts []struct {
+		Labels   map[string]string `json:"labels"`
+		StartsAt time.Time         `json:"startsAt"`
+		EndsAt   *time.Time        `json:"endsAt"`
+	}
+	if err = tg.send(down); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = json.Unmarshal(gotBody, &alerts); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if gotPath != "/api/v2/alerts" || len(alerts) != 1 || alerts[0].Labels["alertname"] != "ProbeDown" ||
+		alerts[0].Labels["probe_name"] != "probe1" || alerts[0].Labels["env"] != "prod" || !alerts[0].StartsAt.Equal(since) || alerts[0].EndsAt != nil {
+		t.Errorf("Got: %s, %s", gotPath, gotBody)
+	}
+	up := down
+	up.State, up.PreviousState, up.Time = misc.StateUp, misc.StateDown, since.Add(time.Hour)
+	if err = tg.send(up); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	alerts = nil
+	if err = json.Unmarshal(gotBody, &alerts); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].EndsAt == nil || !alerts[0].EndsAt.Equal(up.Time) {
+		t.Errorf("Got: %s\n Want a resolved alert", gotBody)
+	}
+}
+
+func TestSetupNotifier(t *testing.T) {
+	n, err := SetupNotifier(nil)
+	if n != nil || err != nil {
+		t.Errorf("Got: %v, %v\n Want: nil, nil", n, err)
+	}
+	// the methods of a nil notifier do nothing.
+	n.Observe("probe1", nil, metric_export.ResultError, misc.StateUnknown, &misc.ProbeStatus{State: misc.StateDown})
+
+	for _, c := range []string{
+		`{"targets": []}`,
+		`{"targets": [{"type": "invalid", "url": "http://localhost"}]}`,
+		`{"targets": [{"type": "slack"}]}`,
+		`{"targets": [{"type": "slack", "url": "http://localhost"}, {"type": "slack", "url": "http://localhost"}]}`,
+		`{"repeat_interval": "1x", "targets": [{"type": "slack", "url": "http://localhost"}]}`,
+		`{"targets": [{"type": "webhook", "url": "http://localhost", "template": "{{.Invalid"}]}`,
+	} {
+		if _, err := SetupNotifier([]byte(c)); err == nil {
+			t.Errorf("Expecting error for config %s, but test is passing", c)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samitpal/goProbe/misc"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The default message of the slack and alertmanager targets.
+const defaultMessage = `{{if .Reminder}}Still {{end}}{{if eq .State "down"}}DOWN{{else if eq .State "flapping"}}FLAPPING{{else}}UP{{end}}` +
+	`: probe {{.ProbeName}}{{if eq .State "down"}} ({{.Result}}, {{.Failures}} consecutive failures){{end}}` +
+	` since {{.Since.Format "2006-01-02 15:04:05 MST"}}`
+
+// TargetConfig is the config of a notify target.
+type TargetConfig struct {
+	Type           string            `json:"type"`            // one of webhook, slack, alertmanager.
+	Name           string            `json:"name"`            // optional, defaults to the type. Needs to be unique.
+	URL            string            `json:"url"`             // the webhook url, or the alertmanager base url.
+	Headers        map[string]string `json:"headers"`         // optional request headers, e.g Authorization.
+	Template       string            `json:"template"`        // optional go text/template of the webhook body, or of the slack and alertmanager message.
+	RepeatInterval string            `json:"repeat_interval"` // optional, overrides the repeat interval of the notify section.
+	Include        []string          `json:"include"`         // optional probe name globs.
+	Exclude        []string          `json:"exclude"`         // optional probe name globs.
+}
+
+// target sends the events to a notify target.
+type target struct {
+	c      TargetConfig
+	tmpl   *template.Template
+	repeat time.Duration
+	client *http.Client
+}
+
+// newTarget returns the target of the given config. The repeat interval of the notify section is used unless
+// the target sets its own. The alertmanager targets default to 1m, as alertmanager resolves the alerts which
+// are not sent again within its resolve_timeout.
+func newTarget(c TargetConfig, repeat time.Duration) (*target, error) {
+	if c.URL == "" {
+		return nil, errors.New("Notify target url is not set")
+	}
+	switch c.Type {
+	case "webhook", "slack":
+	case "alertmanager":
+		c.URL = strings.TrimSuffix(c.URL, "/") + "/api/v2/alerts"
+		if repeat == 0 {
+			repeat = time.Minute
+		}
+	default:
+		return nil, fmt.Errorf("Unknown notify target type '%s'", c.Type)
+	}
+	if c.RepeatInterval != "" {
+		var err error
+		if repeat, err = time.ParseDuration(c.RepeatInterval); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("Invalid probe name glob '%s': %v", g, err)
+		}
+	}
+
+	t := &target{c: c, repeat: repeat, client: &http.Client{Timeout: 10 * time.Second}}
+	tmpl := c.Template
+	if tmpl == "" && c.Type != "webhook" {
+		tmpl = defaultMessage
+	}
+	if tmpl != "" {
+		var err error
+		if t.tmpl, err = template.New(c.Name).Parse(tmpl); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *target) matches(pn string) bool {
+	return matchGlobs(pn, t.c.Include, t.c.Exclude)
+}
+
+// render returns the template applied to the event.
+func (t *target) render(e Event) (string, error) {
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// body returns the request body of the event. A webhook gets the event as json unless it has a template, slack
+// gets the message as text and alertmanager gets a firing alert while the probe is down or flapping, and a resolved
+// one once it is up.
+func (t *target) body(e Event) ([]byte, error) {
+	if t.c.Type == "webhook" {
+		if t.tmpl == nil {
+			return json.Marshal(e)
+		}
+		b, err := t.render(e)
+		return []byte(b), err
+	}
+
+	msg, err := t.render(e)
+	if err != nil {
+		return nil, err
+	}
+	if t.c.Type == "slack" {
+		return json.Marshal(map[string]string{"text": msg})
+	}
+
+	labels := map[string]string{}
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = "ProbeDown"
+	labels["probe_name"] = e.ProbeName
+	alert := map[string]interface{}{
+		"labels":      labels,
+		"annotations": map[string]string{"summary": msg, "result": e.Result},
+		"startsAt":    e.Since.UTC().Format(time.RFC3339),
+	}
+	if e.State == misc.StateUp {
+		// The alert started when the probe went down, which is no longer known. Alertmanager keeps the
+		// start time of the firing alert.
+		alert["startsAt"] = e.Time.UTC().Format(time.RFC3339)
+		alert["endsAt"] = e.Time.UTC().Format(time.RFC3339)
+	}
+	return json.Marshal([]interface{}{alert})
+}
+
+// send posts the event to the target.
+func (t *target) send(e Event) error {
+	body, err := t.body(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		rb, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %s: %s", t.c.URL, resp.Status, bytes.TrimSpace(rb))
+	}
+	return nil
+}
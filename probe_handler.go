@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/samitpal/goProbe/conf"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/misc"
+	"github.com/samitpal/goProbe/modules"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scrapeTimeoutOffset is taken off the scrape timeout of the /probe requests, so that the response makes it back to
+// prometheus in time.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// timeoutProber is a probe run with a shorter timeout than its configured one.
+type timeoutProber struct {
+	modules.Prober
+	timeout *modules.Interval
+}
+
+func (p timeoutProber) Timeout() *modules.Interval {
+	return p.timeout
+}
+
+// scrapeTimeout returns the timeout of the prometheus scrape sending the request, as set by the
+// X-Prometheus-Scrape-Timeout-Seconds header, less scrapeTimeoutOffset if the timeout is long enough. It is 0 if the
+// header is not set.
+func scrapeTimeout(r *http.Request) (time.Duration, error) {
+	h := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if h == "" {
+		return 0, nil
+	}
+	secs, err := strconv.ParseFloat(h, 64)
+	if err != nil || secs <= 0 {
+		return 0, fmt.Errorf("Invalid X-Prometheus-Scrape-Timeout-Seconds '%s'", h)
+	}
+	to := time.Duration(secs * float64(time.Second))
+	if to > 2*scrapeTimeoutOffset {
+		to -= scrapeTimeoutOffset
+	}
+	return to, nil
+}
+
+// handleProbe serves the /probe?module=<name>&target=<addr> requests, in the style of the prometheus blackbox
+// exporter. It sets up the named module template against the given target, runs it once and returns the
+// metrics of that single run in prometheus format. The run is not retried, whatever the retries of the module, so
+// that it fits in the scrape: the probe timeout is cut down to the scrape timeout, and the run is given up if the
+// client goes away.
+func handleProbe(tmpls map[string]conf.Probes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mn := r.URL.Query().Get("module")
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		m, ok := tmpls[mn]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown module '%s'", mn), http.StatusBadRequest)
+			return
+		}
+		to, err := scrapeTimeout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := conf.SetupModuleProbe(mn, m, target)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error setting up module '%s' for target %s: %v", mn, target, err), http.StatusBadRequest)
+			return
+		}
+		if to > 0 && to < p.Timeout().Duration {
+			p = timeoutProber{Prober: p, timeout: &modules.Interval{Duration: to}}
+		}
+
+		stopCh, release := misc.StopChannel(r.Context())
+		defer release()
+		run := runProbeOnce(p, stopCh)
+		if run == nil {
+			glog.Infof("Probe of target %s with module %s given up, the client went away.", target, mn)
+			return
+		}
+		run.attempts = 1
+		glog.Infof("Probe of target %s with module %s: %s", target, mn, run.result)
+
+		mExp := metric_export.NewPrometheusProbeExport()
+		mExp.Prepare()
+		recordProbeRun(*p.Name(), run, mExp, nil)
+		mExp.MetricHttpHandler().ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,372 @@
+package push_metric
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/modules"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PipelineConfig holds the settings of a push pipeline.
+type PipelineConfig struct {
+	QueueSize     int           // max number of probe runs waiting in memory to be pushed.
+	BatchSize     int           // max number of probe runs pushed at once.
+	FlushInterval time.Duration // max time a probe run waits for its batch to fill up.
+	MaxRetries    int           // number of retries of a failed push before the batch is spooled or dropped.
+	MinBackoff    time.Duration // wait before the first retry. It is doubled on each further retry.
+	MaxBackoff    time.Duration // max wait between two retries.
+	SpoolDir      string        // optional. Directory where the batches which could not be pushed are kept.
+	MaxSpoolSize  int64         // max size in bytes of the spool file.
+	Include       []string      // optional. Only the probes whose names match one of these globs are pushed.
+	Exclude       []string      // optional. The probes whose names match one of these globs are not pushed.
+}
+
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		QueueSize:     1000,
+		BatchSize:     100,
+		FlushInterval: 10 * time.Second,
+		MaxRetries:    3,
+		MinBackoff:    time.Second,
+		MaxBackoff:    30 * time.Second,
+		MaxSpoolSize:  64 << 20,
+	}
+}
+
+// Pipeline pushes the probe metrics through a Pusher. Probe runs are queued in memory and pushed in batches by a
+// single goroutine, so a slow backend can not pile up goroutines. A failed push is retried with an exponential
+// backoff. Batches which still fail, or probe runs which do not fit in the queue, are appended to an on-disk
+// spool if one is configured, and dropped otherwise. The spool is replayed after the next successful push,
+// including after a restart.
+type Pipeline struct {
+	name   string
+	pusher Pusher
+	c      PipelineConfig
+	queue  chan metric_export.ProbeSamples
+	stopCh chan bool
+	doneCh chan bool
+
+	spoolLock sync.Mutex
+
+	// counters exposed as self metrics. Accessed atomically.
+	sent       uint64
+	dropped    uint64
+	sendErrors uint64
+}
+
+func NewPipeline(name string, p Pusher, c PipelineConfig) (*Pipeline, error) {
+	if c.QueueSize < 1 || c.BatchSize < 1 {
+		return nil, errors.New("Push queue and batch sizes need to be at least 1")
+	}
+	if c.FlushInterval <= 0 {
+		return nil, errors.New("Push flush interval needs to be positive")
+	}
+	for _, g := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("Invalid probe name glob '%s': %v", g, err)
+		}
+	}
+	if c.SpoolDir != "" {
+		if err := os.MkdirAll(c.SpoolDir, 0775); err != nil {
+			return nil, err
+		}
+	}
+	return &Pipeline{
+		name:   name,
+		pusher: p,
+		c:      c,
+		queue:  make(chan metric_export.ProbeSamples, c.QueueSize),
+		stopCh: make(chan bool),
+		doneCh: make(chan bool),
+	}, nil
+}
+
+// Start sets up the pusher and starts pushing the queued probe runs.
+func (pl *Pipeline) Start() {
+	pl.pusher.Setup()
+	go pl.loop()
+}
+
+// Stop pushes what is left in the queue, without retrying, and stops the pipeline.
+func (pl *Pipeline) Stop() {
+	close(pl.stopCh)
+	<-pl.doneCh
+}
+
+// Matches returns whether the metrics of the given probe are pushed by the pipeline, as per its include and
+// exclude globs.
+func (pl *Pipeline) Matches(pn string) bool {
+	included := len(pl.c.Include) == 0
+	for _, g := range pl.c.Include {
+		if ok, _ := path.Match(g, pn); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, g := range pl.c.Exclude {
+		if ok, _ := path.Match(g, pn); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Enqueue queues the given probe run. If the queue is full, the probe run is spooled or dropped.
+func (pl *Pipeline) Enqueue(ps metric_export.ProbeSamples) {
+	select {
+	case pl.queue <- ps:
+	default:
+		glog.Warningf("Push queue of %s is full", pl.name)
+		pl.spoolOrDrop([]metric_export.ProbeSamples{ps})
+	}
+}
+
+// RegisterMetrics adds the pipeline's own metrics, labeled with the pipeline name, to the given exporter.
+func (pl *Pipeline) RegisterMetrics(mExp metric_export.MetricExporter) {
+	labels := map[string]string{"provider": pl.name}
+	counter := func(c *uint64) func() float64 {
+		return func() float64 { return float64(atomic.LoadUint64(c)) }
+	}
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "push_queue_depth", Help: "Number of probe runs waiting to be pushed.", Labels: labels,
+		Value: func() float64 { return float64(len(pl.queue)) }})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "push_spool_bytes", Help: "Size of the push spool file.", Labels: labels,
+		Value: func() float64 { return float64(pl.spoolSize()) }})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "push_sent_total", Help: "Number of probe runs pushed.", Counter: true, Labels: labels,
+		Value: counter(&pl.sent)})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "push_dropped_total", Help: "Number of probe runs dropped without being pushed.", Counter: true, Labels: labels,
+		Value: counter(&pl.dropped)})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "push_send_errors_total", Help: "Number of failed push attempts.", Counter: true, Labels: labels,
+		Value: counter(&pl.sendErrors)})
+}
+
+func (pl *Pipeline) loop() {
+	defer close(pl.doneCh)
+	ticker := time.NewTicker(pl.c.FlushInterval)
+	defer ticker.Stop()
+
+	pl.replaySpool() // push what was left over by a previous run.
+	var batch []metric_export.ProbeSamples
+	for {
+		select {
+		case ps := <-pl.queue:
+			batch = append(batch, ps)
+			if len(batch) < pl.c.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				pl.replaySpool()
+				continue
+			}
+		case <-pl.stopCh:
+			for len(pl.queue) > 0 {
+				batch = append(batch, <-pl.queue)
+			}
+			if len(batch) > 0 {
+				if err := pl.pusher.Push(batch); err != nil {
+					glog.Errorf("Error pushing metrics to %s: %v", pl.name, err)
+					atomic.AddUint64(&pl.sendErrors, 1)
+					pl.spoolOrDrop(batch)
+				} else {
+					atomic.AddUint64(&pl.sent, uint64(len(batch)))
+				}
+			}
+			return
+		}
+		if pl.send(batch) {
+			pl.replaySpool()
+		}
+		batch = nil
+	}
+}
+
+// send pushes the batch, retrying with an exponential backoff. The batch is spooled or dropped if all the
+// attempts fail. It returns whether the push succeeded.
+func (pl *Pipeline) send(batch []metric_export.ProbeSamples) bool {
+	backoff := pl.c.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := pl.pusher.Push(batch)
+		if err == nil {
+			atomic.AddUint64(&pl.sent, uint64(len(batch)))
+			return true
+		}
+		atomic.AddUint64(&pl.sendErrors, 1)
+		glog.Errorf("Error pushing metrics to %s (attempt %d): %v", pl.name, attempt+1, err)
+		if attempt >= pl.c.MaxRetries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-pl.stopCh:
+			pl.spoolOrDrop(batch)
+			return false
+		}
+		backoff *= 2
+		if backoff > pl.c.MaxBackoff {
+			backoff = pl.c.MaxBackoff
+		}
+	}
+	pl.spoolOrDrop(batch)
+	return false
+}
+
+func (pl *Pipeline) spoolPath() string {
+	return filepath.Join(pl.c.SpoolDir, pl.name+".spool")
+}
+
+func (pl *Pipeline) spoolSize() int64 {
+	if pl.c.SpoolDir == "" {
+		return 0
+	}
+	fi, err := os.Stat(pl.spoolPath())
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// spoolOrDrop appends the probe runs to the spool file, one json object per line. They are dropped if there is
+// no spool or if the spool is full.
+func (pl *Pipeline) spoolOrDrop(batch []metric_export.ProbeSamples) {
+	if pl.c.SpoolDir == "" {
+		atomic.AddUint64(&pl.dropped, uint64(len(batch)))
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ps := range batch {
+		enc.Encode(ps)
+	}
+
+	pl.spoolLock.Lock()
+	defer pl.spoolLock.Unlock()
+	if pl.spoolSize()+int64(buf.Len()) > pl.c.MaxSpoolSize {
+		glog.Errorf("Push spool of %s is full, dropping %d probe runs", pl.name, len(batch))
+		atomic.AddUint64(&pl.dropped, uint64(len(batch)))
+		return
+	}
+	f, err := os.OpenFile(pl.spoolPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		glog.Errorf("Error writing push spool of %s: %v", pl.name, err)
+		atomic.AddUint64(&pl.dropped, uint64(len(batch)))
+	}
+}
+
+// replaySpool pushes the spooled probe runs. Whatever can not be pushed is put back in the spool.
+func (pl *Pipeline) replaySpool() {
+	if pl.c.SpoolDir == "" {
+		return
+	}
+	pl.spoolLock.Lock()
+	data, err := ioutil.ReadFile(pl.spoolPath())
+	if err == nil {
+		os.Remove(pl.spoolPath())
+	}
+	pl.spoolLock.Unlock()
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	var pending []metric_export.ProbeSamples
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var ps metric_export.ProbeSamples
+		if err := json.Unmarshal(scanner.Bytes(), &ps); err != nil {
+			// most likely a partial write, e.g on a crash.
+			glog.Errorf("Skipping corrupt entry in push spool of %s: %v", pl.name, err)
+			atomic.AddUint64(&pl.dropped, 1)
+			continue
+		}
+		pending = append(pending, ps)
+	}
+	glog.Infof("Replaying %d spooled probe runs to %s", len(pending), pl.name)
+
+	for len(pending) > 0 {
+		n := pl.c.BatchSize
+		if n > len(pending) {
+			n = len(pending)
+		}
+		if err := pl.pusher.Push(pending[:n]); err != nil {
+			glog.Errorf("Error pushing spooled metrics to %s: %v", pl.name, err)
+			atomic.AddUint64(&pl.sendErrors, 1)
+			pl.spoolOrDrop(pending)
+			return
+		}
+		atomic.AddUint64(&pl.sent, uint64(n))
+		pending = pending[n:]
+	}
+}
+
+// Pipelines is the set of push pipelines every probe run fans out to.
+type Pipelines []*Pipeline
+
+// PushProbe queues the current metrics of the given probe to all the pipelines it matches. It never blocks.
+func (pls Pipelines) PushProbe(mExp metric_export.MetricExporter, p modules.Prober) {
+	var ps *metric_export.ProbeSamples
+	for _, pl := range pls {
+		if !pl.Matches(*p.Name()) {
+			continue
+		}
+		if ps == nil {
+			ps = &metric_export.ProbeSamples{ProbeName: *p.Name(), Labels: p.Options().ProbeLabels, Samples: mExp.Snapshot(*p.Name())}
+		}
+		pl.Enqueue(*ps)
+	}
+}
+
+// Seed seeds the pushers of the pipelines which are Seeders with the current metrics of the given probes, e.g once
+// restored from the state file. It needs to be called before Start.
+func (pls Pipelines) Seed(mExp metric_export.MetricExporter, probes []modules.Prober) {
+	for _, pl := range pls {
+		s, ok := pl.pusher.(Seeder)
+		if !ok {
+			continue
+		}
+		var batch []metric_export.ProbeSamples
+		for _, p := range probes {
+			if pl.Matches(*p.Name()) {
+				batch = append(batch, metric_export.ProbeSamples{ProbeName: *p.Name(), Labels: p.Options().ProbeLabels, Samples: mExp.Snapshot(*p.Name())})
+			}
+		}
+		s.Seed(batch)
+	}
+}
+
+func (pls Pipelines) RegisterMetrics(mExp metric_export.MetricExporter) {
+	for _, pl := range pls {
+		pl.RegisterMetrics(mExp)
+	}
+}
+
+func (pls Pipelines) Start() {
+	for _, pl := range pls {
+		pl.Start()
+	}
+}
+
+func (pls Pipelines) Stop() {
+	for _, pl := range pls {
+		pl.Stop()
+	}
+}
@@ -0,0 +1,141 @@
+package push_metric
+
+import (
+	"errors"
+	"github.com/samitpal/goProbe/metric_export"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPusher fails the first 'failures' pushes and records the probe names of the successful ones.
+type testPusher struct {
+	lock     sync.Mutex
+	failures int
+	calls    int
+	pushed   []string
+}
+
+func (tp *testPusher) Setup() {}
+
+func (tp *testPusher) Push(batch []metric_export.ProbeSamples) error {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	tp.calls++
+	if tp.failures > 0 {
+		tp.failures--
+		return errors.New("push failed")
+	}
+	for _, ps := range batch {
+		tp.pushed = append(tp.pushed, ps.ProbeName)
+	}
+	return nil
+}
+
+func (tp *testPusher) get() (int, []string) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	return tp.calls, append([]string(nil), tp.pushed...)
+}
+
+func testConfig() PipelineConfig {
+	c := DefaultPipelineConfig()
+	c.BatchSize = 2
+	c.FlushInterval = 10 * time.Millisecond
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 2 * time.Millisecond
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the pipeline")
+}
+
+func TestPipelineRetry(t *testing.T) {
+	tp := &testPusher{failures: 2}
+	pl, err := NewPipeline("test", tp, testConfig())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	pl.Start()
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe1"})
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe2"})
+	waitFor(t, func() bool { _, p := tp.get(); return len(p) == 2 })
+	pl.Stop()
+
+	calls, _ := tp.get()
+	if calls != 3 {
+		t.Errorf("Got %d push calls, Want: 3", calls)
+	}
+	if pl.sendErrors != 2 || pl.sent != 2 || pl.dropped != 0 {
+		t.Errorf("Got sent: %d, send errors: %d, dropped: %d\n Want: 2, 2, 0", pl.sent, pl.sendErrors, pl.dropped)
+	}
+}
+
+func TestPipelineDrop(t *testing.T) {
+	c := testConfig()
+	c.QueueSize = 1
+	c.MaxRetries = 0
+	tp := &testPusher{failures: 1}
+	pl, err := NewPipeline("test", tp, c)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	// the pipeline is not started, so the second probe run does not fit in the queue.
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe1"})
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe2"})
+	if pl.dropped != 1 {
+		t.Errorf("Got %d dropped, Want: 1", pl.dropped)
+	}
+	pl.Start()
+	pl.Stop()
+	if pl.dropped != 2 {
+		t.Errorf("Got %d dropped, Want: 2", pl.dropped)
+	}
+}
+
+func TestPipelineSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goprobe_spool")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := testConfig()
+	c.MaxRetries = 0
+	c.SpoolDir = dir
+	tp := &testPusher{failures: 100}
+	pl, err := NewPipeline("test", tp, c)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	pl.Start()
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe1"})
+	pl.Enqueue(metric_export.ProbeSamples{ProbeName: "probe2"})
+	waitFor(t, func() bool { return pl.spoolSize() > 0 })
+	pl.Stop()
+	if pl.dropped != 0 {
+		t.Errorf("Got %d dropped, Want: 0", pl.dropped)
+	}
+
+	// a new pipeline, as after a restart, pushes the spooled probe runs once the backend recovers.
+	tp = &testPusher{}
+	pl, err = NewPipeline("test", tp, c)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	pl.Start()
+	waitFor(t, func() bool { _, p := tp.get(); return len(p) == 2 })
+	pl.Stop()
+	if pl.spoolSize() != 0 {
+		t.Errorf("Got spool size %d, Want: 0", pl.spoolSize())
+	}
+}
@@ -1,21 +1,80 @@
 package provider
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/golang/glog"
-	"github.com/marpaia/graphite-golang"
 	"github.com/samitpal/goProbe/metric_export"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	graphiteUDPMaxPacket = 1400 // keeps the udp datagrams under the usual ethernet mtu.
+	graphiteTimeout      = 10 * time.Second
+)
+
+// GraphiteConfig holds the graphite provider settings.
+type GraphiteConfig struct {
+	Host     string `json:"-"`
+	Port     int    `json:"-"`
+	Prefix   string `json:"-"`        // optional, defaults to goProbe.
+	Protocol string `json:"protocol"` // tcp (default) or udp.
+	Format   string `json:"format"`   // plaintext (default), pickle or tagged.
+	// Template is the metric path, e.g {prefix}.{label.env}.{probe}.{metric}. {label.<name>} is replaced by the value
+	// of the given probe label and is dropped along with its dot if the probe does not have the label. Defaults to
+	// {prefix}.{probe}.{metric}, or {prefix}.{metric} for the tagged format where the probe name and labels are tags.
+	Template string `json:"template"`
+}
+
 type graphitePush struct {
-	g *graphite.Graphite
+	c    GraphiteConfig
+	addr string
+	conn net.Conn
 }
 
-func NewGraphitePusher(host string, port int) (*graphitePush, error) {
-	graphite, err := graphite.NewGraphiteWithMetricPrefix(host, port, "goProbe")
-	if err != nil {
-		return nil, err
+// NewGraphitePusher returns a graphite pusher. The connection to carbon is made on the first push and is made
+// again whenever carbon drops it.
+func NewGraphitePusher(c GraphiteConfig) (*graphitePush, error) {
+	if c.Prefix == "" {
+		c.Prefix = "goProbe"
+	}
+	switch c.Protocol {
+	case "":
+		c.Protocol = "tcp"
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("Unknown graphite protocol '%s'. Use tcp or udp.", c.Protocol)
+	}
+	switch c.Format {
+	case "":
+		c.Format = "plaintext"
+	case "plaintext", "tagged":
+	case "pickle":
+		if c.Protocol == "udp" {
+			return nil, errors.New("Graphite pickle format is not supported over udp")
+		}
+	default:
+		return nil, fmt.Errorf("Unknown graphite format '%s'. Use plaintext, pickle or tagged.", c.Format)
 	}
-	return &graphitePush{graphite}, nil
+	if c.Template == "" {
+		if c.Format == "tagged" {
+			c.Template = "{prefix}.{metric}"
+		} else {
+			c.Template = "{prefix}.{probe}.{metric}"
+		}
+	}
+	if !strings.Contains(c.Template, "{metric}") {
+		return nil, errors.New("Graphite template needs to contain {metric}")
+	}
+	return &graphitePush{c: c, addr: net.JoinHostPort(c.Host, strconv.Itoa(c.Port))}, nil
 }
 
 // Currently not used. Hence doing nothing
@@ -23,10 +82,221 @@ func (g *graphitePush) Setup() {
 
 }
 
-func (g *graphitePush) PushMetric(mExp metric_export.MetricExporter, pn string) {
-	metrics := mExp.RetGraphiteMetrics(pn)
-	err := g.g.SendMetrics(metrics)
+// graphiteMetric is a single data point.
+type graphiteMetric struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+func (g *graphitePush) Push(batch []metric_export.ProbeSamples) error {
+	var metrics []graphiteMetric
+	for _, ps := range batch {
+		for _, s := range ps.Samples {
+			metrics = append(metrics, graphiteMetric{path: g.path(ps, s), value: s.Value, timestamp: s.Timestamp})
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var payloads [][]byte
+	switch {
+	case g.c.Format == "pickle":
+		payloads = [][]byte{graphitePickle(metrics)}
+	case g.c.Protocol == "udp":
+		payloads = graphitePackets(metrics, graphiteUDPMaxPacket)
+	default:
+		payloads = graphitePackets(metrics, 0)
+	}
+
+	if err := g.connect(); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		g.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+		if _, err := g.conn.Write(p); err != nil {
+			// Drop the connection so that the retry of the pipeline reconnects.
+			g.conn.Close()
+			g.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// connect (re)connects to carbon if there is no connection or if carbon has closed it.
+func (g *graphitePush) connect() error {
+	if g.conn != nil && g.c.Protocol == "tcp" && !tcpAlive(g.conn) {
+		glog.Infof("Graphite connection to %s was closed, reconnecting.", g.addr)
+		g.conn.Close()
+		g.conn = nil
+	}
+	if g.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(g.c.Protocol, g.addr, graphiteTimeout)
 	if err != nil {
-		glog.Infof("Error pushing metric", err)
+		return err
+	}
+	g.conn = conn
+	return nil
+}
+
+// tcpAlive tells whether the peer has not closed the connection. Carbon never writes to its clients, so a read
+// either times out on a live connection or returns EOF on a closed one.
+func tcpAlive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	conn.SetReadDeadline(time.Time{})
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
 	}
+	return err == nil
+}
+
+// path returns the metric path of the sample as given by the template. For the tagged format the probe name,
+// the probe labels and the sample labels are appended as tags, e.g goProbe.up;env=prod;probe_name=probe1
+func (g *graphitePush) path(ps metric_export.ProbeSamples, s metric_export.Sample) string {
+	tagged := g.c.Format == "tagged"
+	metric := s.Name
+	if !tagged {
+		metric = flatName(s, ".")
+	}
+
+	var nodes []string
+	for _, node := range splitTemplate(g.c.Template) {
+		node = strings.Replace(node, "{prefix}", g.c.Prefix, -1)
+		node = strings.Replace(node, "{probe}", graphiteNode(ps.ProbeName), -1)
+		node = strings.Replace(node, "{metric}", metric, -1)
+		for strings.Contains(node, "{label.") {
+			start := strings.Index(node, "{label.")
+			end := strings.Index(node[start:], "}")
+			if end < 0 {
+				break
+			}
+			name := node[start+len("{label.") : start+end]
+			node = node[:start] + graphiteNode(ps.Labels[name]) + node[start+end+1:]
+		}
+		if node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	path := strings.Join(nodes, ".")
+	if !tagged {
+		return path
+	}
+
+	tags := map[string]string{}
+	for k, v := range ps.Labels {
+		tags[k] = v
+	}
+	for k, v := range s.Labels {
+		tags[k] = v
+	}
+	tags["probe_name"] = ps.ProbeName
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if tags[k] != "" {
+			path = path + ";" + graphiteTag(k) + "=" + graphiteTag(tags[k])
+		}
+	}
+	return path
+}
+
+// splitTemplate splits the template in its path nodes, i.e on the dots which are not within braces.
+func splitTemplate(tmpl string) []string {
+	var nodes []string
+	depth, start := 0, 0
+	for i, r := range tmpl {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '.':
+			if depth == 0 {
+				nodes = append(nodes, tmpl[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(nodes, tmpl[start:])
+}
+
+// graphiteNode makes the given value usable as a single node of a metric path.
+func graphiteNode(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '\t', '\n', ';':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// graphiteTag makes the given value usable as a tag name or value.
+func graphiteTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ';', '~', '!', '^', '=', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// graphitePackets returns the metrics in the plaintext format, e.g "goProbe.probe1.up 1 1450000000\n". If max is
+// not 0 the metrics are split in packets of at most max bytes, otherwise they are returned as a single packet.
+func graphitePackets(metrics []graphiteMetric, max int) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		line := fmt.Sprintf("%s %s %d\n", m.path, strconv.FormatFloat(m.value, 'f', -1, 64), m.timestamp)
+		if max > 0 && buf.Len() > 0 && buf.Len()+len(line) > max {
+			packets = append(packets, buf.Bytes())
+			buf = bytes.Buffer{}
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+// graphitePickle returns the metrics in the pickle format, i.e the length prefixed pickle (protocol 2) of the list
+// [(path, (timestamp, value)), ...]
+func graphitePickle(metrics []graphiteMetric) []byte {
+	var p bytes.Buffer
+	p.Write([]byte{0x80, 2}) // PROTO 2
+	p.WriteByte(']')         // EMPTY_LIST
+	p.WriteByte('(')         // MARK
+	for _, m := range metrics {
+		p.WriteByte('X') // BINUNICODE
+		binary.Write(&p, binary.LittleEndian, uint32(len(m.path)))
+		p.WriteString(m.path)
+		pickleFloat(&p, float64(m.timestamp))
+		pickleFloat(&p, m.value)
+		p.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		p.WriteByte(0x86) // TUPLE2 (path, (timestamp, value))
+	}
+	p.WriteByte('e') // APPENDS
+	p.WriteByte('.') // STOP
+
+	out := make([]byte, 4, 4+p.Len())
+	binary.BigEndian.PutUint32(out, uint32(p.Len()))
+	return append(out, p.Bytes()...)
+}
+
+// pickleFloat writes a BINFLOAT opcode.
+func pickleFloat(w io.Writer, f float64) {
+	var b [9]byte
+	b[0] = 'G'
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	w.Write(b[:])
 }
@@ -0,0 +1,210 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/samitpal/goProbe/metric_export"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// carbon is a local tcp listener standing in for the carbon server. It sends the received lines on lines and the
+// accepted connections on conns.
+type carbon struct {
+	l     net.Listener
+	lines chan string
+	conns chan net.Conn
+}
+
+func newCarbon(t *testing.T) *carbon {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	c := &carbon{l: l, lines: make(chan string, 100), conns: make(chan net.Conn, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.conns <- conn
+			go func() {
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					c.lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return c
+}
+
+func (c *carbon) config() GraphiteConfig {
+	addr := c.l.Addr().(*net.TCPAddr)
+	return GraphiteConfig{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func (c *carbon) readLines(t *testing.T, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case l := <-c.lines:
+			got = append(got, l)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for line %d, got: %v", i+1, got)
+		}
+	}
+	return got
+}
+
+func testGraphiteBatch() []metric_export.ProbeSamples {
+	return []metric_export.ProbeSamples{
+		{
+			ProbeName: "probe1",
+			Labels:    map[string]string{"env": "prod"},
+			Samples: []metric_export.Sample{
+				{Name: "up", Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+				{Name: "result", Value: 2, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1", "result": "timeout"}},
+			},
+		},
+		{
+			ProbeName: "probe2",
+			Samples:   []metric_export.Sample{{Name: "latency", Value: 12.5, Timestamp: 1450000010, Labels: map[string]string{"probe_name": "probe2"}}},
+		},
+	}
+}
+
+func TestGraphitePushPlaintext(t *testing.T) {
+	c := newCarbon(t)
+	defer c.l.Close()
+
+	gc := c.config()
+	gc.Template = "{prefix}.{label.env}.{probe}.{metric}"
+	g, err := NewGraphitePusher(gc)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = g.Push(testGraphiteBatch()); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want := []string{
+		"goProbe.prod.probe1.up 1 1450000000",
+		"goProbe.prod.probe1.result.timeout 2 1450000000",
+		"goProbe.probe2.latency 12.5 1450000010",
+	}
+	got := c.readLines(t, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Got: %v\n Want: %v", got[i], want[i])
+		}
+	}
+}
+
+func TestGraphiteReconnect(t *testing.T) {
+	c := newCarbon(t)
+	defer c.l.Close()
+
+	g, err := NewGraphitePusher(c.config())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	batch := testGraphiteBatch()[1:]
+	if err = g.Push(batch); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	c.readLines(t, 1)
+
+	// carbon drops the connection, the next push needs to reconnect.
+	(<-c.conns).Close()
+	time.Sleep(50 * time.Millisecond)
+	if err = g.Push(batch); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got := c.readLines(t, 1)[0]; got != "goProbe.probe2.latency 12.5 1450000010" {
+		t.Errorf("Got: %v\n Want: goProbe.probe2.latency 12.5 1450000010", got)
+	}
+}
+
+func TestGraphitePushUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer pc.Close()
+
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	g, err := NewGraphitePusher(GraphiteConfig{Host: addr.IP.String(), Port: addr.Port, Protocol: "udp", Prefix: "gp"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = g.Push(testGraphiteBatch()[1:]); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got := string(buf[:n]); got != "gp.probe2.latency 12.5 1450000010\n" {
+		t.Errorf("Got: %q\n Want: %q", got, "gp.probe2.latency 12.5 1450000010\n")
+	}
+}
+
+func TestGraphiteTaggedPath(t *testing.T) {
+	g, err := NewGraphitePusher(GraphiteConfig{Host: "localhost", Port: 2003, Format: "tagged"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ps := testGraphiteBatch()[0]
+	want := "goProbe.result;env=prod;probe_name=probe1;result=timeout"
+	if got := g.path(ps, ps.Samples[1]); got != want {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	p := graphitePickle([]graphiteMetric{{path: "goProbe.probe1.up", value: 1, timestamp: 1450000000}})
+	if n := binary.BigEndian.Uint32(p); int(n) != len(p)-4 {
+		t.Errorf("Got length header: %d\n Want: %d", n, len(p)-4)
+	}
+	body := p[4:]
+	if !bytes.HasPrefix(body, []byte{0x80, 2, ']', '(', 'X'}) || !bytes.HasSuffix(body, []byte("e.")) {
+		t.Errorf("Got: %q, not a pickled list", body)
+	}
+	if !bytes.Contains(body, []byte("goProbe.probe1.up")) {
+		t.Errorf("Got: %q, missing the metric path", body)
+	}
+
+	for _, c := range []GraphiteConfig{
+		{Protocol: "udp", Format: "pickle"},
+		{Format: "json"},
+		{Template: "{prefix}.{probe}"},
+	} {
+		if _, err := NewGraphitePusher(c); err == nil {
+			t.Errorf("Expecting error for config %+v, but test is passing", c)
+		}
+	}
+}
+
+func TestGraphitePackets(t *testing.T) {
+	var metrics []graphiteMetric
+	for i := 0; i < 100; i++ {
+		metrics = append(metrics, graphiteMetric{path: "goProbe.probe" + strconv.Itoa(i) + ".up", value: 1, timestamp: 1450000000})
+	}
+	packets := graphitePackets(metrics, 200)
+	total := 0
+	for _, p := range packets {
+		if len(p) > 200 {
+			t.Errorf("Got packet of %d bytes, Want at most 200", len(p))
+		}
+		total += bytes.Count(p, []byte("\n"))
+	}
+	if total != 100 {
+		t.Errorf("Got %d lines, Want: 100", total)
+	}
+}
@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/samitpal/goProbe/metric_export"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxConfig holds the influxdb provider settings. Setting Org, Bucket and Token makes the provider use the v2
+// write api (/api/v2/write), otherwise the v1 write api (/write) is used with Database and optional credentials.
+type InfluxConfig struct {
+	URL         string `json:"-"`        // base url of the influxdb server, e.g http://localhost:8086
+	Measurement string `json:"-"`        // optional, defaults to probe.
+	Database    string `json:"database"` // v1 only.
+	User        string `json:"user"`     // v1 only, optional.
+	Password    string `json:"password"` // v1 only, optional.
+	Org         string `json:"org"`      // v2 only.
+	Bucket      string `json:"bucket"`   // v2 only.
+	Token       string `json:"token"`    // v2 only.
+}
+
+type influxPush struct {
+	c        InfluxConfig
+	writeURL string
+	client   *http.Client
+}
+
+func NewInfluxPusher(c InfluxConfig) (*influxPush, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("precision", "s")
+	if c.Org != "" || c.Bucket != "" || c.Token != "" {
+		if c.Org == "" || c.Bucket == "" || c.Token == "" {
+			return nil, errors.New("Influxdb v2 needs the org, bucket and token to be set")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		q.Set("org", c.Org)
+		q.Set("bucket", c.Bucket)
+	} else {
+		if c.Database == "" {
+			return nil, errors.New("Influxdb database is not set")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+		q.Set("db", c.Database)
+		if c.User != "" {
+			q.Set("u", c.User)
+			q.Set("p", c.Password)
+		}
+	}
+	u.RawQuery = q.Encode()
+	if c.Measurement == "" {
+		c.Measurement = "probe"
+	}
+	return &influxPush{c: c, writeURL: u.String(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Currently not used. Hence doing nothing
+func (ip *influxPush) Setup() {
+
+}
+
+// Push writes one point per probe run. The points are tagged with the probe name and the probe labels and are
+// timestamped with the start time of the probe run.
+func (ip *influxPush) Push(batch []metric_export.ProbeSamples) error {
+	var points []string
+	for _, ps := range batch {
+		if point := influxPoint(ip.c.Measurement, ps); point != "" {
+			points = append(points, point)
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", ip.writeURL, strings.NewReader(strings.Join(points, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if ip.c.Token != "" {
+		req.Header.Set("Authorization", "Token "+ip.c.Token)
+	}
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Influxdb write returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// influxPoint returns the metrics of the given probe as a point in line protocol, e.g
+// probe,env=prod,probe_name=probe1 count=10,latency=123.4,up=1 1450000000
+func influxPoint(measurement string, ps metric_export.ProbeSamples) string {
+	var fields []string
+	var ts int64
+	for _, s := range ps.Samples {
+		name := flatName(s, "_")
+		fields = append(fields, influxEscape(name, ",= ")+"="+strconv.FormatFloat(s.Value, 'g', -1, 64))
+		if name == "count" {
+			ts = s.Timestamp // the start time of the last probe run.
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	sort.Strings(fields)
+
+	tags := map[string]string{}
+	for k, v := range ps.Labels {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	tags["probe_name"] = ps.ProbeName
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // influxdb performs best with the tags sorted by key.
+	var tagSet []string
+	for _, k := range keys {
+		tagSet = append(tagSet, influxEscape(k, ",= ")+"="+influxEscape(tags[k], ",= "))
+	}
+	return fmt.Sprintf("%s,%s %s %d", influxEscape(measurement, ", "), strings.Join(tagSet, ","), strings.Join(fields, ","), ts)
+}
+
+// influxEscape escapes the given characters with a backslash as required by the line protocol.
+func influxEscape(s string, chars string) string {
+	for _, c := range chars {
+		s = strings.Replace(s, string(c), `\`+string(c), -1)
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"github.com/samitpal/goProbe/metric_export"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInfluxPushV2(t *testing.T) {
+	var gotPath, gotQuery, gotAuth, gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		gotAuth = r.Header.Get("Authorization")
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	ip, err := NewInfluxPusher(InfluxConfig{URL: ts.URL, Org: "org1", Bucket: "probes", Token: "secret"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	batch := []metric_export.ProbeSamples{
+		{
+			ProbeName: "probe1",
+			Labels:    map[string]string{"env": "prod", "team": "web ops"},
+			Samples: []metric_export.Sample{
+				{Name: "count", Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+				{Name: "result", Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1", "result": "success"}},
+				{Name: "up", Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+				{Name: "latency", Value: 12.5, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+			},
+		},
+		{
+			ProbeName: "probe 2",
+			Samples: []metric_export.Sample{
+				{Name: "count", Value: 3, Timestamp: 1450000010, Labels: map[string]string{"probe_name": "probe 2"}},
+				{Name: "up", Value: 0, Timestamp: 1450000010, Labels: map[string]string{"probe_name": "probe 2"}},
+			},
+		},
+	}
+	if err = ip.Push(batch); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if gotPath != "/api/v2/write" {
+		t.Errorf("Got: %v\n Want: /api/v2/write", gotPath)
+	}
+	if gotQuery != "bucket=probes&org=org1&precision=s" {
+		t.Errorf("Got: %v\n Want: bucket=probes&org=org1&precision=s", gotQuery)
+	}
+	if gotAuth != "Token secret" {
+		t.Errorf("Got: %v\n Want: Token secret", gotAuth)
+	}
+	want := `probe,env=prod,probe_name=probe1,team=web\ ops count=1,latency=12.5,result_success=1,up=1 1450000000
+probe,probe_name=probe\ 2 count=3,up=0 1450000010
+`
+	if gotBody != want {
+		t.Errorf("Got: \n%v\n Want: \n%v", gotBody, want)
+	}
+}
+
+func TestInfluxPushV1(t *testing.T) {
+	var gotPath, gotQuery string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	ip, err := NewInfluxPusher(InfluxConfig{URL: ts.URL, Database: "goprobe", User: "u1", Password: "p1"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	batch := []metric_export.ProbeSamples{{ProbeName: "probe1", Samples: []metric_export.Sample{{Name: "count", Value: 1, Timestamp: 1450000000}}}}
+	if err = ip.Push(batch); err == nil {
+		t.Error("Expecting error due to the bad request response, but test is passing")
+	}
+	if gotPath != "/write" {
+		t.Errorf("Got: %v\n Want: /write", gotPath)
+	}
+	if gotQuery != "db=goprobe&p=p1&precision=s&u=u1" {
+		t.Errorf("Got: %v\n Want: db=goprobe&p=p1&precision=s&u=u1", gotQuery)
+	}
+
+	if _, err = NewInfluxPusher(InfluxConfig{URL: ts.URL, Org: "org1"}); err == nil {
+		t.Error("Expecting error due to missing bucket and token, but test is passing")
+	}
+}
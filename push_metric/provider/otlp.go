@@ -0,0 +1,291 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/version"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultOTLPBuckets are the latency histogram bucket bounds, in milli seconds.
+var defaultOTLPBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// OTLPConfig holds the OpenTelemetry (OTLP/HTTP) provider settings.
+type OTLPConfig struct {
+	URL        string            `json:"-"`                   // the collector url, e.g http://localhost:4318. /v1/metrics is added if there is no path.
+	Prefix     string            `json:"-"`                   // optional metric name prefix, defaults to goprobe.
+	Headers    map[string]string `json:"headers"`             // optional request headers, e.g Authorization.
+	Attributes map[string]string `json:"resource_attributes"` // optional resource attributes in addition to service.name and host.name.
+	Buckets    []float64         `json:"buckets"`             // optional latency histogram bucket bounds in milli seconds.
+}
+
+// otlpHistogram is the cumulative latency histogram of a probe.
+type otlpHistogram struct {
+	counts []uint64 // one more than the bucket bounds, the last one counting the values above the last bound.
+	count  uint64
+	sum    float64
+}
+
+type otlpPush struct {
+	c         OTLPConfig
+	resource  *resourcepb.Resource
+	startTime uint64 // the start time of the cumulative metrics, Unix epoch in nano seconds.
+	hist      map[string]*otlpHistogram
+	client    *http.Client
+}
+
+// NewOTLPPusher returns an OTLP/HTTP pusher. The probe counts are sent as cumulative sums, the up, state and payload size as
+// gauges, and the latency as a cumulative explicit bucket histogram of the probe runs pushed so far.
+func NewOTLPPusher(c OTLPConfig) (*otlpPush, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid OTLP url '%s'", c.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	c.URL = u.String()
+	if c.Prefix == "" {
+		c.Prefix = "goprobe"
+	}
+	if len(c.Buckets) == 0 {
+		c.Buckets = defaultOTLPBuckets
+	}
+	if !sort.Float64sAreSorted(c.Buckets) {
+		return nil, fmt.Errorf("OTLP histogram buckets need to be sorted, got %v", c.Buckets)
+	}
+
+	attrs := map[string]string{"service.name": "goProbe", "service.version": version.Version}
+	if host, err := os.Hostname(); err == nil {
+		attrs["host.name"] = host
+	}
+	for k, v := range c.Attributes {
+		attrs[k] = v
+	}
+	return &otlpPush{
+		c:         c,
+		resource:  &resourcepb.Resource{Attributes: otlpAttributes(attrs)},
+		startTime: uint64(time.Now().UnixNano()),
+		hist:      make(map[string]*otlpHistogram),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Currently not used. Hence doing nothing
+func (op *otlpPush) Setup() {
+
+}
+
+// otlpBatch collects the data points of a push, keeping the last point of each series.
+type otlpBatch struct {
+	metrics []*metricspb.Metric
+	byName  map[string]*metricspb.Metric
+	points  map[string]int // index of the data point of a series within its metric.
+}
+
+// metric returns the metric of the given name, creating it with newMetric if needed.
+func (b *otlpBatch) metric(name string, newMetric func() *metricspb.Metric) *metricspb.Metric {
+	if m, ok := b.byName[name]; ok {
+		return m
+	}
+	m := newMetric()
+	m.Name = name
+	b.byName[name] = m
+	b.metrics = append(b.metrics, m)
+	return m
+}
+
+// index returns the index of the data point of the given series if it is already in the batch, otherwise -1
+// after recording n as its index.
+func (b *otlpBatch) index(name, series string, n int) int {
+	key := name + "|" + series
+	if i, ok := b.points[key]; ok {
+		return i
+	}
+	b.points[key] = n
+	return -1
+}
+
+// otlpCounters are the samples sent as cumulative sums, the latency being a histogram and the other ones, e.g up or
+// paused, gauges.
+var otlpCounters = map[string]bool{"count": true, "error_count": true, "timeout_count": true, "result": true, "retry_count": true}
+
+func (op *otlpPush) Push(batch []metric_export.ProbeSamples) error {
+	// The histograms are only committed once sent, so that a retried batch is not counted twice.
+	hist := make(map[string]*otlpHistogram)
+	b := &otlpBatch{byName: make(map[string]*metricspb.Metric), points: make(map[string]int)}
+
+	for _, ps := range batch {
+		for _, s := range ps.Samples {
+			attrs := make(map[string]string)
+			for k, v := range ps.Labels {
+				attrs[k] = v
+			}
+			for k, v := range s.Labels {
+				attrs[k] = v
+			}
+			attrs["probe_name"] = ps.ProbeName
+			series := otlpSeries(attrs)
+			name := op.c.Prefix + "." + s.Name
+			ts := uint64(s.Timestamp) * uint64(time.Second)
+
+			switch {
+			case s.Name == "latency":
+				if s.Value < 0 {
+					continue // the probe did not respond.
+				}
+				h, ok := hist[series]
+				if !ok {
+					h = &otlpHistogram{counts: make([]uint64, len(op.c.Buckets)+1)}
+					if prev, ok := op.hist[series]; ok {
+						h.count, h.sum = prev.count, prev.sum
+						copy(h.counts, prev.counts)
+					}
+					hist[series] = h
+				}
+				h.counts[sort.SearchFloat64s(op.c.Buckets, s.Value)]++
+				h.count++
+				h.sum += s.Value
+
+				m := b.metric(name, func() *metricspb.Metric {
+					return &metricspb.Metric{Unit: "ms", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					}}}
+				})
+				hg := m.GetHistogram()
+				sum := h.sum
+				dp := &metricspb.HistogramDataPoint{
+					Attributes:        otlpAttributes(attrs),
+					StartTimeUnixNano: op.startTime,
+					TimeUnixNano:      ts,
+					Count:             h.count,
+					Sum:               &sum,
+					BucketCounts:      append([]uint64(nil), h.counts...),
+					ExplicitBounds:    op.c.Buckets,
+				}
+				if i := b.index(name, series, len(hg.DataPoints)); i >= 0 {
+					hg.DataPoints[i] = dp
+				} else {
+					hg.DataPoints = append(hg.DataPoints, dp)
+				}
+			case otlpCounters[s.Name]:
+				m := b.metric(name, func() *metricspb.Metric {
+					return &metricspb.Metric{Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						IsMonotonic:            true,
+					}}}
+				})
+				sm := m.GetSum()
+				dp := &metricspb.NumberDataPoint{
+					Attributes:        otlpAttributes(attrs),
+					StartTimeUnixNano: op.startTime,
+					TimeUnixNano:      ts,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: s.Value},
+				}
+				if i := b.index(name, series, len(sm.DataPoints)); i >= 0 {
+					sm.DataPoints[i] = dp
+				} else {
+					sm.DataPoints = append(sm.DataPoints, dp)
+				}
+			default:
+				m := b.metric(name, func() *metricspb.Metric {
+					return &metricspb.Metric{Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}}
+				})
+				if s.Name == "payload_size" {
+					m.Unit = "By"
+				}
+				g := m.GetGauge()
+				dp := &metricspb.NumberDataPoint{
+					Attributes:   otlpAttributes(attrs),
+					TimeUnixNano: ts,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: s.Value},
+				}
+				if i := b.index(name, series, len(g.DataPoints)); i >= 0 {
+					g.DataPoints[i] = dp
+				} else {
+					g.DataPoints = append(g.DataPoints, dp)
+				}
+			}
+		}
+	}
+	if len(b.metrics) == 0 {
+		return nil
+	}
+
+	// MetricsData has the same wire format as the ExportMetricsServiceRequest of the collector.
+	body, err := proto.Marshal(&metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: op.resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: "github.com/samitpal/goProbe", Version: version.Version},
+			Metrics: b.metrics,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", op.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range op.c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := op.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		rb, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP export returned %s: %s", resp.Status, bytes.TrimSpace(rb))
+	}
+
+	for k, h := range hist {
+		op.hist[k] = h
+	}
+	return nil
+}
+
+// otlpAttributes returns the given attributes as OTLP string attributes, sorted by key.
+func otlpAttributes(attrs map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var kvs []*commonpb.KeyValue
+	for _, k := range keys {
+		if attrs[k] == "" {
+			continue
+		}
+		kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attrs[k]}}})
+	}
+	return kvs
+}
+
+// otlpSeries returns a key identifying the series of the given attributes.
+func otlpSeries(attrs map[string]string) string {
+	var kvs []string
+	for k, v := range attrs {
+		if v != "" {
+			kvs = append(kvs, k+"="+v)
+		}
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
@@ -0,0 +1,150 @@
+package provider
+
+import (
+	"github.com/samitpal/goProbe/metric_export"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testOTLPBatch(count, latency float64, t int64) []metric_export.ProbeSamples {
+	return []metric_export.ProbeSamples{{
+		ProbeName: "probe1",
+		Labels:    map[string]string{"env": "prod"},
+		Samples: []metric_export.Sample{
+			{Name: "count", Value: count, Timestamp: t, Labels: map[string]string{"probe_name": "probe1"}},
+			{Name: "up", Value: 1, Timestamp: t, Labels: map[string]string{"probe_name": "probe1"}},
+			{Name: "latency", Value: latency, Timestamp: t, Labels: map[string]string{"probe_name": "probe1"}},
+		},
+	}}
+}
+
+func TestOTLPPush(t *testing.T) {
+	var got []*metricspb.MetricsData
+	var gotPath, gotType string
+	fail := false
+	// a fake collector.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotPath, gotType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		md := &metricspb.MetricsData{}
+		if err := proto.Unmarshal(body, md); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		got = append(got, md)
+	}))
+	defer ts.Close()
+
+	op, err := NewOTLPPusher(OTLPConfig{URL: ts.URL, Buckets: []float64{10, 100}, Attributes: map[string]string{"deployment.environment": "test"}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = op.Push(testOTLPBatch(1, 5, 1450000000)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	// a failed push must not be counted in the histogram.
+	fail = true
+	if err = op.Push(testOTLPBatch(2, 50, 1450000010)); err == nil {
+		t.Errorf("Expecting error from the failing collector, but test is passing")
+	}
+	fail = false
+	if err = op.Push(testOTLPBatch(2, 50, 1450000010)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if gotPath != "/v1/metrics" || gotType != "application/x-protobuf" {
+		t.Errorf("Got: %s, %s\n Want: /v1/metrics, application/x-protobuf", gotPath, gotType)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Got %d exports, Want: 2", len(got))
+	}
+
+	rm := got[1].ResourceMetrics[0]
+	res := make(map[string]string)
+	for _, kv := range rm.Resource.Attributes {
+		res[kv.Key] = kv.Value.GetStringValue()
+	}
+	if res["service.name"] != "goProbe" || res["deployment.environment"] != "test" || res["host.name"] == "" {
+		t.Errorf("Got resource attributes: %v", res)
+	}
+
+	metrics := make(map[string]*metricspb.Metric)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	sum := metrics["goprobe.count"].GetSum()
+	if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("Got: %v\n Want a cumulative monotonic sum", metrics["goprobe.count"])
+	}
+	if v := sum.DataPoints[0].GetAsDouble(); v != 2 {
+		t.Errorf("Got count: %v\n Want: 2", v)
+	}
+	attrs := make(map[string]string)
+	for _, kv := range sum.DataPoints[0].Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	if !reflect.DeepEqual(attrs, map[string]string{"env": "prod", "probe_name": "probe1"}) {
+		t.Errorf("Got attributes: %v", attrs)
+	}
+	if metrics["goprobe.up"].GetGauge() == nil {
+		t.Errorf("Got: %v\n Want a gauge", metrics["goprobe.up"])
+	}
+
+	h := metrics["goprobe.latency"].GetHistogram()
+	if h == nil {
+		t.Fatalf("Got: %v\n Want a histogram", metrics["goprobe.latency"])
+	}
+	dp := h.DataPoints[0]
+	if dp.Count != 2 || dp.GetSum() != 55 || !reflect.DeepEqual(dp.BucketCounts, []uint64{1, 1, 0}) {
+		t.Errorf("Got histogram: count %d, sum %v, buckets %v\n Want: count 2, sum 55, buckets [1 1 0]", dp.Count, dp.GetSum(), dp.BucketCounts)
+	}
+	if dp.StartTimeUnixNano != sum.DataPoints[0].StartTimeUnixNano || dp.TimeUnixNano != 1450000010*1e9 {
+		t.Errorf("Got start time %d, time %d", dp.StartTimeUnixNano, dp.TimeUnixNano)
+	}
+}
+
+func TestOTLPPushGauges(t *testing.T) {
+	var got *metricspb.MetricsData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = &metricspb.MetricsData{}
+		if err := proto.Unmarshal(body, got); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}))
+	defer ts.Close()
+	op, err := NewOTLPPusher(OTLPConfig{URL: ts.URL})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// the samples going down between the runs, e.g the attempts or the probe flags, are gauges, not counters.
+	gauges := []string{"attempts", metric_export.FlagPaused, metric_export.FlagSilenced, metric_export.FlagInMaintenance}
+	var samples []metric_export.Sample
+	for _, n := range gauges {
+		samples = append(samples, metric_export.Sample{Name: n, Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}})
+	}
+	if err = op.Push([]metric_export.ProbeSamples{{ProbeName: "probe1", Samples: samples}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got == nil {
+		t.Fatal("Expecting an export")
+	}
+	metrics := make(map[string]*metricspb.Metric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	for _, n := range gauges {
+		if g := metrics["goprobe."+n].GetGauge(); g == nil || len(g.DataPoints) != 1 || g.DataPoints[0].GetAsDouble() != 1 {
+			t.Errorf("%s: Got: %v\n Want a gauge", n, metrics["goprobe."+n])
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package provider holds the push providers, which format the probe metric samples and push them to a
+// given backend (e.g graphite, influxdb).
+package provider
+
+import (
+	"github.com/samitpal/goProbe/metric_export"
+	"sort"
+)
+
+// flatName returns the sample name followed by the values of its labels other than probe_name, sorted by label
+// name and joined by the given separator. E.g the result sample labeled with result=timeout gives result.timeout
+// with "." as the separator.
+func flatName(s metric_export.Sample, sep string) string {
+	var keys []string
+	for k := range s.Labels {
+		if k != "probe_name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	name := s.Name
+	for _, k := range keys {
+		name = name + sep + s.Labels[k]
+	}
+	return name
+}
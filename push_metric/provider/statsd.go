@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"bytes"
+	"github.com/samitpal/goProbe/metric_export"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const statsdMaxPacket = 1432 // the statsd recommended max udp payload for ethernet.
+
+// StatsdConfig holds the statsd provider settings.
+type StatsdConfig struct {
+	Addr   string `json:"-"`    // host:port of the statsd agent.
+	Prefix string `json:"-"`    // optional, defaults to goProbe.
+	Tags   bool   `json:"tags"` // whether to send the probe name and labels as DogStatsD tags.
+}
+
+type statsdPush struct {
+	c    StatsdConfig
+	conn net.Conn
+	last map[string]float64 // the last pushed value of the counters, keyed by metric and tags.
+}
+
+// NewStatsdPusher returns a statsd pusher. The up, state, latency, payload size, attempts and probe flags are sent as
+// gauges, the latency also as a timing, and the probe counts as counters incremented by the change since the last push, or since the values
+// it is seeded with.
+func NewStatsdPusher(c StatsdConfig) (*statsdPush, error) {
+	if c.Prefix == "" {
+		c.Prefix = "goProbe"
+	}
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdPush{c: c, conn: conn, last: make(map[string]float64)}, nil
+}
+
+// Currently not used. Hence doing nothing
+func (sp *statsdPush) Setup() {
+
+}
+
+// Seed sets the counter values the next push starts from. It implements push_metric.Seeder.
+func (sp *statsdPush) Seed(batch []metric_export.ProbeSamples) {
+	for _, ps := range batch {
+		for _, s := range ps.Samples {
+			if statsdCounters[s.Name] {
+				name, tags := sp.name(ps, s)
+				sp.last[name+"|"+tags] = s.Value
+			}
+		}
+	}
+}
+
+// statsdCounters are the samples sent as counters, the other ones, e.g up or paused, being gauges.
+var statsdCounters = map[string]bool{"count": true, "error_count": true, "timeout_count": true, "result": true, "retry_count": true}
+
+func (sp *statsdPush) Push(batch []metric_export.ProbeSamples) error {
+	// The counters are only committed once sent, so that a retried batch sends the same increments.
+	last := make(map[string]float64)
+	var lines []string
+	for _, ps := range batch {
+		for _, s := range ps.Samples {
+			name, tags := sp.name(ps, s)
+			if !statsdCounters[s.Name] {
+				if s.Value < 0 {
+					// A leading sign makes statsd change the gauge by the value, hence reset it to 0 first.
+					lines = append(lines, statsdLine(name, "0", "g", tags))
+				}
+				lines = append(lines, statsdLine(name, strconv.FormatFloat(s.Value, 'f', -1, 64), "g", tags))
+				if s.Name == "latency" && s.Value >= 0 {
+					lines = append(lines, statsdLine(name, strconv.FormatFloat(s.Value, 'f', -1, 64), "ms", tags))
+				}
+			} else {
+				key := name + "|" + tags
+				prev, ok := last[key]
+				if !ok {
+					prev = sp.last[key]
+				}
+				delta := s.Value - prev
+				if delta < 0 {
+					delta = s.Value // the counter has been reset.
+				}
+				last[key] = s.Value
+				if delta > 0 {
+					lines = append(lines, statsdLine(name, strconv.FormatFloat(delta, 'f', -1, 64), "c", tags))
+				}
+			}
+		}
+	}
+
+	for _, p := range statsdPackets(lines, statsdMaxPacket) {
+		if _, err := sp.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	for k, v := range last {
+		sp.last[k] = v
+	}
+	return nil
+}
+
+// name returns the metric name and the DogStatsD tags of the sample, e.g goProbe.result and
+// env:prod,probe_name:probe1,result:timeout with tags, goProbe.probe1.result.timeout without.
+func (sp *statsdPush) name(ps metric_export.ProbeSamples, s metric_export.Sample) (string, string) {
+	if !sp.c.Tags {
+		return sp.c.Prefix + "." + statsdName(ps.ProbeName) + "." + statsdName(flatName(s, ".")), ""
+	}
+	tags := map[string]string{}
+	for k, v := range ps.Labels {
+		tags[k] = v
+	}
+	for k, v := range s.Labels {
+		tags[k] = v
+	}
+	tags["probe_name"] = ps.ProbeName
+	var tagList []string
+	for k, v := range tags {
+		if v != "" {
+			tagList = append(tagList, statsdTag(k)+":"+statsdTag(v))
+		}
+	}
+	sort.Strings(tagList)
+	return sp.c.Prefix + "." + statsdName(s.Name), strings.Join(tagList, ",")
+}
+
+// statsdLine returns a metric in the statsd format, e.g goProbe.up:1|g|#probe_name:probe1
+func statsdLine(name, value, typ, tags string) string {
+	line := name + ":" + value + "|" + typ
+	if tags != "" {
+		line = line + "|#" + tags
+	}
+	return line
+}
+
+// statsdName replaces the characters which have a meaning in the statsd format.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// statsdTag replaces the characters which have a meaning in the DogStatsD tags.
+func statsdTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', ':', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// statsdPackets joins the lines by newlines in packets of at most max bytes.
+func statsdPackets(lines []string, max int) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > max {
+			packets = append(packets, buf.Bytes())
+			buf = bytes.Buffer{}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
@@ -0,0 +1,170 @@
+package provider
+
+import (
+	"github.com/samitpal/goProbe/metric_export"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readStatsd reads a datagram from the udp listener standing in for the statsd agent.
+func readStatsd(t *testing.T, pc net.PacketConn) []string {
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func testStatsdBatch(count, timeouts float64, up float64) []metric_export.ProbeSamples {
+	return []metric_export.ProbeSamples{{
+		ProbeName: "probe1",
+		Labels:    map[string]string{"env": "prod"},
+		Samples: []metric_export.Sample{
+			{Name: "count", Value: count, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+			{Name: "result", Value: timeouts, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1", "result": "timeout"}},
+			{Name: "up", Value: up, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+			{Name: "latency", Value: 12.5, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}},
+		},
+	}}
+}
+
+func TestStatsdPush(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer pc.Close()
+
+	sp, err := NewStatsdPusher(StatsdConfig{Addr: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = sp.Push(testStatsdBatch(2, 1, 1)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want := []string{
+		"goProbe.probe1.count:2|c",
+		"goProbe.probe1.result.timeout:1|c",
+		"goProbe.probe1.up:1|g",
+		"goProbe.probe1.latency:12.5|g",
+		"goProbe.probe1.latency:12.5|ms",
+	}
+	if got := readStatsd(t, pc); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+
+	// counters are sent as the change since the last push, negative gauges are reset first.
+	if err = sp.Push(testStatsdBatch(5, 1, -1)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want = []string{
+		"goProbe.probe1.count:3|c",
+		"goProbe.probe1.up:0|g",
+		"goProbe.probe1.up:-1|g",
+		"goProbe.probe1.latency:12.5|g",
+		"goProbe.probe1.latency:12.5|ms",
+	}
+	if got := readStatsd(t, pc); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestStatsdPushTags(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer pc.Close()
+
+	sp, err := NewStatsdPusher(StatsdConfig{Addr: pc.LocalAddr().String(), Prefix: "gp", Tags: true})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = sp.Push(testStatsdBatch(2, 1, 1)[:1]); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	got := readStatsd(t, pc)
+	want := []string{
+		"gp.count:2|c|#env:prod,probe_name:probe1",
+		"gp.result:1|c|#env:prod,probe_name:probe1,result:timeout",
+		"gp.up:1|g|#env:prod,probe_name:probe1",
+		"gp.latency:12.5|g|#env:prod,probe_name:probe1",
+		"gp.latency:12.5|ms|#env:prod,probe_name:probe1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestStatsdPushRestored(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer pc.Close()
+
+	// the counters saved before a restart.
+	saved := metric_export.NewJSONExport()
+	for i := 0; i < 50000; i++ {
+		saved.IncProbeCount("probe1", 1450000000)
+		saved.IncProbeResultCount("probe1", metric_export.ResultSuccess, 1450000000)
+	}
+	mExp := metric_export.NewJSONExport()
+	mExp.Restore("probe1", saved.Snapshot("probe1"))
+
+	sp, err := NewStatsdPusher(StatsdConfig{Addr: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	sp.Seed([]metric_export.ProbeSamples{{ProbeName: "probe1", Samples: mExp.Snapshot("probe1")}})
+
+	// the first push after the restart only sends the change since the restored counters.
+	mExp.IncProbeCount("probe1", 1450000060)
+	mExp.IncProbeResultCount("probe1", metric_export.ResultTimeout, 1450000060)
+	if err = sp.Push([]metric_export.ProbeSamples{{ProbeName: "probe1", Samples: mExp.Snapshot("probe1")}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	want := []string{
+		"goProbe.probe1.count:1|c",
+		"goProbe.probe1.result.timeout:1|c",
+	}
+	if got := readStatsd(t, pc); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+}
+
+func TestStatsdPushGauges(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer pc.Close()
+
+	sp, err := NewStatsdPusher(StatsdConfig{Addr: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	batch := []metric_export.ProbeSamples{{ProbeName: "probe1", Samples: []metric_export.Sample{
+		{Name: "attempts", Value: 2, Timestamp: 1450000000},
+		{Name: "retry_count", Value: 1, Timestamp: 1450000000},
+		{Name: metric_export.FlagPaused, Value: 0, Timestamp: 1450000000},
+	}}}
+	for i := 0; i < 2; i++ {
+		if err = sp.Push(batch); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		// the retry count is a counter, the attempts and the flags are gauges sent on every push.
+		want := []string{"goProbe.probe1.attempts:2|g", "goProbe.probe1.paused:0|g"}
+		if i == 0 {
+			want = []string{"goProbe.probe1.attempts:2|g", "goProbe.probe1.retry_count:1|c", "goProbe.probe1.paused:0|g"}
+		}
+		if got := readStatsd(t, pc); !reflect.DeepEqual(got, want) {
+			t.Errorf("Push %d: Got: %v\n Want: %v", i, got, want)
+		}
+	}
+}
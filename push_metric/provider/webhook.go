@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samitpal/goProbe/metric_export"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig holds the webhook provider settings.
+type WebhookConfig struct {
+	URL     string            `json:"-"`       // the url the metrics are posted to.
+	Headers map[string]string `json:"headers"` // optional request headers, e.g Authorization.
+}
+
+type webhookPush struct {
+	c      WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookPusher(c WebhookConfig) (*webhookPush, error) {
+	if c.URL == "" {
+		return nil, errors.New("Webhook url is not set")
+	}
+	return &webhookPush{c: c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Currently not used. Hence doing nothing
+func (wp *webhookPush) Setup() {
+
+}
+
+// Push posts the batch as a json list of probe runs, each with its probe name, labels and samples.
+func (wp *webhookPush) Push(batch []metric_export.ProbeSamples) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", wp.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wp.c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := wp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Webhook returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
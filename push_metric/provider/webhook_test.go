@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"encoding/json"
+	"github.com/samitpal/goProbe/metric_export"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWebhookPush(t *testing.T) {
+	var got []metric_export.ProbeSamples
+	var gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	wp, err := NewWebhookPusher(WebhookConfig{URL: ts.URL, Headers: map[string]string{"Authorization": "Bearer secret"}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	batch := []metric_export.ProbeSamples{{
+		ProbeName: "probe1",
+		Labels:    map[string]string{"env": "prod"},
+		Samples:   []metric_export.Sample{{Name: "up", Value: 1, Timestamp: 1450000000, Labels: map[string]string{"probe_name": "probe1"}}},
+	}}
+	if err = wp.Push(batch); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(got, batch) {
+		t.Errorf("Got: %v\n Want: %v", got, batch)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Got: %v\n Want: Bearer secret", gotAuth)
+	}
+}
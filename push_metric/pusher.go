@@ -1,18 +1,202 @@
 package push_metric
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/samitpal/goProbe/metric_export"
 	"github.com/samitpal/goProbe/push_metric/provider"
+	"net"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Pusher is the interface that needs needs to implement for pushing metric to (e.g graphite. influxdb).
 type Pusher interface {
+	// Setup is called once, before any metric is pushed.
 	Setup()
-	PushMetric(metric_export.MetricExporter, string)
+
+	// Push formats and pushes the samples of the given probe runs. It is called by a single goroutine of the
+	// Pipeline. A returned error makes the Pipeline retry the same batch later.
+	Push([]metric_export.ProbeSamples) error
+}
+
+// Seeder is implemented by the pushers sending the change of the counters since their last push, e.g statsd.
+type Seeder interface {
+	// Seed sets the counter values the next push starts from, e.g the ones restored from the state file, so that
+	// they are not sent again as a change.
+	Seed([]metric_export.ProbeSamples)
+}
+
+/* Example json config of the push section. Each entry sets up a push provider, all of which get the metrics of
+every probe run matching their include/exclude globs.
+"push": [
+    {
+        "provider": "graphite",
+        "endpoint": "carbon.example.com:2003",
+        "prefix": "goProbe",
+        "exclude": ["*_staging"],
+        "options": {
+            "format": "tagged"
+        }
+    },
+    {
+        "provider": "influxdb",
+        "endpoint": "http://influx.example.com:8086",
+        "flush_interval": "30s",
+        "options": {
+            "org": "ops",
+            "bucket": "probes",
+            "token": "secret"
+        }
+    }
+]
+*/
+
+// ProviderConfig is the config of a push provider, as listed in the push section of the config.
+type ProviderConfig struct {
+	Provider      string          `json:"provider"`       // one of graphite, influxdb, statsd, dogstatsd, otlp, webhook.
+	Name          string          `json:"name"`           // optional, defaults to the provider. Needs to be unique.
+	Endpoint      string          `json:"endpoint"`       // host:port for graphite and statsd, the url for influxdb, otlp and webhook.
+	Prefix        string          `json:"prefix"`         // metric prefix for graphite, statsd and otlp, measurement for influxdb.
+	Include       []string        `json:"include"`        // optional probe name globs.
+	Exclude       []string        `json:"exclude"`        // optional probe name globs.
+	FlushInterval string          `json:"flush_interval"` // e.g 30s.
+	QueueSize     int             `json:"queue_size"`
+	BatchSize     int             `json:"batch_size"`
+	MaxRetries    *int            `json:"max_retries"`
+	SpoolDir      string          `json:"spool_dir"`
+	Options       json.RawMessage `json:"options"` // provider specific settings.
+}
+
+// SetupPipelines sets up a push pipeline for each of the providers listed in the given push config section.
+func SetupPipelines(config json.RawMessage) (Pipelines, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+	var pcs []ProviderConfig
+	if err := json.Unmarshal(config, &pcs); err != nil {
+		return nil, err
+	}
+	var pls Pipelines
+	names := make(map[string]bool)
+	for _, pc := range pcs {
+		if pc.Name == "" {
+			pc.Name = pc.Provider
+		}
+		if names[pc.Name] {
+			return nil, fmt.Errorf("Duplicate push provider name '%s'. Set a unique name for each provider.", pc.Name)
+		}
+		names[pc.Name] = true
+
+		p, err := newProvider(pc)
+		if err != nil {
+			return nil, fmt.Errorf("Push provider '%s': %v", pc.Name, err)
+		}
+		c := DefaultPipelineConfig()
+		c.Include = pc.Include
+		c.Exclude = pc.Exclude
+		c.SpoolDir = pc.SpoolDir
+		if pc.FlushInterval != "" {
+			if c.FlushInterval, err = time.ParseDuration(pc.FlushInterval); err != nil {
+				return nil, fmt.Errorf("Push provider '%s': %v", pc.Name, err)
+			}
+		}
+		if pc.QueueSize != 0 {
+			c.QueueSize = pc.QueueSize
+		}
+		if pc.BatchSize != 0 {
+			c.BatchSize = pc.BatchSize
+		}
+		if pc.MaxRetries != nil {
+			c.MaxRetries = *pc.MaxRetries
+		}
+		pl, err := NewPipeline(pc.Name, p, c)
+		if err != nil {
+			return nil, fmt.Errorf("Push provider '%s': %v", pc.Name, err)
+		}
+		pls = append(pls, pl)
+	}
+	return pls, nil
+}
+
+// newProvider sets up the pusher of the given provider config.
+func newProvider(pc ProviderConfig) (Pusher, error) {
+	switch pc.Provider {
+	case "graphite":
+		host, port, err := splitHostPort(pc.Endpoint, 2003)
+		if err != nil {
+			return nil, err
+		}
+		c := provider.GraphiteConfig{Host: host, Port: port, Prefix: pc.Prefix}
+		if len(pc.Options) > 0 {
+			if err := json.Unmarshal(pc.Options, &c); err != nil {
+				return nil, err
+			}
+		}
+		return provider.NewGraphitePusher(c)
+	case "influxdb", "influx":
+		c := provider.InfluxConfig{URL: pc.Endpoint, Measurement: pc.Prefix}
+		if len(pc.Options) > 0 {
+			if err := json.Unmarshal(pc.Options, &c); err != nil {
+				return nil, err
+			}
+		}
+		return provider.NewInfluxPusher(c)
+	case "webhook":
+		c := provider.WebhookConfig{URL: pc.Endpoint}
+		if len(pc.Options) > 0 {
+			if err := json.Unmarshal(pc.Options, &c); err != nil {
+				return nil, err
+			}
+		}
+		return provider.NewWebhookPusher(c)
+	case "statsd", "dogstatsd":
+		c := provider.StatsdConfig{Addr: pc.Endpoint, Prefix: pc.Prefix, Tags: pc.Provider == "dogstatsd"}
+		if c.Addr == "" {
+			c.Addr = "localhost:8125"
+		}
+		if len(pc.Options) > 0 {
+			if err := json.Unmarshal(pc.Options, &c); err != nil {
+				return nil, err
+			}
+		}
+		return provider.NewStatsdPusher(c)
+	case "otlp":
+		c := provider.OTLPConfig{URL: pc.Endpoint, Prefix: pc.Prefix}
+		if c.URL == "" {
+			c.URL = "http://localhost:4318"
+		}
+		if len(pc.Options) > 0 {
+			if err := json.Unmarshal(pc.Options, &c); err != nil {
+				return nil, err
+			}
+		}
+		return provider.NewOTLPPusher(c)
+		// Add a new case statement here for a new push provider.
+	}
+	return nil, fmt.Errorf("Unknown push provider '%s'", pc.Provider)
 }
 
+// splitHostPort splits a host:port endpoint. The port is optional.
+func splitHostPort(endpoint string, defaultPort int) (string, int, error) {
+	if endpoint == "" {
+		return "localhost", defaultPort, nil
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint, defaultPort, nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid port in endpoint %s", endpoint)
+	}
+	return host, p, nil
+}
+
+// SetupProviders sets up the push provider named by the GOPROBE_PUSH_TO env variable. This is the way to
+// configure a single provider, without the push section in the config.
 func SetupProviders() (Pusher, error) {
 	if os.Getenv("GOPROBE_PUSH_TO") == "graphite" {
 		graphite_host := "localhost"
@@ -21,9 +205,86 @@ func SetupProviders() (Pusher, error) {
 		}
 		graphite_port := 2003
 		if os.Getenv("GOPROBE_GRAPHITE_PORT") != "" {
-			graphite_host = os.Getenv("GOPROBE_GRAPHITE_PORT")
+			port, err := strconv.Atoi(os.Getenv("GOPROBE_GRAPHITE_PORT"))
+			if err != nil {
+				return nil, fmt.Errorf("GOPROBE_GRAPHITE_PORT: %v", err)
+			}
+			graphite_port = port
+		}
+		return provider.NewGraphitePusher(provider.GraphiteConfig{
+			Host:     graphite_host,
+			Port:     graphite_port,
+			Prefix:   os.Getenv("GOPROBE_GRAPHITE_PREFIX"),
+			Protocol: os.Getenv("GOPROBE_GRAPHITE_PROTOCOL"),
+			Format:   os.Getenv("GOPROBE_GRAPHITE_FORMAT"),
+			Template: os.Getenv("GOPROBE_GRAPHITE_TEMPLATE"),
+		})
+	} else if os.Getenv("GOPROBE_PUSH_TO") == "influxdb" {
+		return setupInfluxProvider()
+	} else if os.Getenv("GOPROBE_PUSH_TO") == "statsd" {
+		c := provider.StatsdConfig{Addr: "localhost:8125", Prefix: os.Getenv("GOPROBE_STATSD_PREFIX")}
+		if os.Getenv("GOPROBE_STATSD_ADDR") != "" {
+			c.Addr = os.Getenv("GOPROBE_STATSD_ADDR")
+		}
+		if os.Getenv("GOPROBE_STATSD_TAGS") != "" {
+			tags, err := strconv.ParseBool(os.Getenv("GOPROBE_STATSD_TAGS"))
+			if err != nil {
+				return nil, fmt.Errorf("GOPROBE_STATSD_TAGS: %v", err)
+			}
+			c.Tags = tags
 		}
-		return provider.NewGraphitePusher(graphite_host, graphite_port)
+		return provider.NewStatsdPusher(c)
+	} else if os.Getenv("GOPROBE_PUSH_TO") == "otlp" {
+		c := provider.OTLPConfig{URL: "http://localhost:4318"}
+		if os.Getenv("GOPROBE_OTLP_URL") != "" {
+			c.URL = os.Getenv("GOPROBE_OTLP_URL")
+		}
+		return provider.NewOTLPPusher(c)
 	}
 	return nil, errors.New("No push provider found")
 }
+
+func setupInfluxProvider() (Pusher, error) {
+	c := provider.InfluxConfig{
+		URL:      "http://localhost:8086",
+		Database: os.Getenv("GOPROBE_INFLUXDB_DB"),
+		User:     os.Getenv("GOPROBE_INFLUXDB_USER"),
+		Password: os.Getenv("GOPROBE_INFLUXDB_PASSWORD"),
+		Org:      os.Getenv("GOPROBE_INFLUXDB_ORG"),
+		Bucket:   os.Getenv("GOPROBE_INFLUXDB_BUCKET"),
+		Token:    os.Getenv("GOPROBE_INFLUXDB_TOKEN"),
+	}
+	if os.Getenv("GOPROBE_INFLUXDB_URL") != "" {
+		c.URL = os.Getenv("GOPROBE_INFLUXDB_URL")
+	}
+	return provider.NewInfluxPusher(c)
+}
+
+// SetupPipeline sets up the push pipeline of the given pusher. The defaults can be overridden by the
+// GOPROBE_PUSH_* env variables.
+func SetupPipeline(name string, p Pusher) (*Pipeline, error) {
+	c := DefaultPipelineConfig()
+	ints := map[string]*int{
+		"GOPROBE_PUSH_QUEUE_SIZE":  &c.QueueSize,
+		"GOPROBE_PUSH_BATCH_SIZE":  &c.BatchSize,
+		"GOPROBE_PUSH_MAX_RETRIES": &c.MaxRetries,
+	}
+	for env, i := range ints {
+		if os.Getenv(env) != "" {
+			v, err := strconv.Atoi(os.Getenv(env))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", env, err)
+			}
+			*i = v
+		}
+	}
+	if os.Getenv("GOPROBE_PUSH_FLUSH_INTERVAL") != "" {
+		d, err := time.ParseDuration(os.Getenv("GOPROBE_PUSH_FLUSH_INTERVAL"))
+		if err != nil {
+			return nil, fmt.Errorf("GOPROBE_PUSH_FLUSH_INTERVAL: %v", err)
+		}
+		c.FlushInterval = d
+	}
+	c.SpoolDir = os.Getenv("GOPROBE_PUSH_SPOOL_DIR")
+	return NewPipeline(name, p, c)
+}
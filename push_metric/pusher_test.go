@@ -0,0 +1,71 @@
+package push_metric
+
+import (
+	"testing"
+)
+
+func TestSetupPipelines(t *testing.T) {
+	config := []byte(`[
+        {
+            "provider": "influxdb",
+            "endpoint": "http://localhost:8086",
+            "exclude": ["*_staging"],
+            "options": {"database": "goprobe"}
+        },
+        {
+            "provider": "webhook",
+            "name": "hook",
+            "endpoint": "http://localhost:9000/metrics",
+            "include": ["web_*"],
+            "flush_interval": "1s"
+        }
+    ]`)
+	pls, err := SetupPipelines(config)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(pls) != 2 {
+		t.Fatalf("Got %d pipelines, Want: 2", len(pls))
+	}
+	if pls[0].name != "influxdb" || pls[1].name != "hook" {
+		t.Errorf("Got names: %s, %s\n Want: influxdb, hook", pls[0].name, pls[1].name)
+	}
+
+	tests := []struct {
+		pl   *Pipeline
+		pn   string
+		want bool
+	}{
+		{pls[0], "web_frontend", true},
+		{pls[0], "web_staging", false},
+		{pls[1], "web_frontend", true},
+		{pls[1], "db_primary", false},
+	}
+	for _, tt := range tests {
+		if got := tt.pl.Matches(tt.pn); got != tt.want {
+			t.Errorf("%s matching %s. Got: %v\n Want: %v", tt.pl.name, tt.pn, got, tt.want)
+		}
+	}
+
+	// duplicate names, unknown providers and invalid globs are errors.
+	for _, c := range []string{
+		`[{"provider": "webhook", "endpoint": "http://localhost"}, {"provider": "webhook", "endpoint": "http://localhost"}]`,
+		`[{"provider": "invalid"}]`,
+		`[{"provider": "webhook", "endpoint": "http://localhost", "include": ["[web"]}]`,
+	} {
+		if _, err := SetupPipelines([]byte(c)); err == nil {
+			t.Errorf("Expecting error for config %s, but test is passing", c)
+		}
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	host, port, err := splitHostPort("carbon.example.com:2013", 2003)
+	if err != nil || host != "carbon.example.com" || port != 2013 {
+		t.Errorf("Got: %v, %v, %v\n Want: carbon.example.com, 2013, nil", host, port, err)
+	}
+	host, port, err = splitHostPort("carbon.example.com", 2003)
+	if err != nil || host != "carbon.example.com" || port != 2003 {
+		t.Errorf("Got: %v, %v, %v\n Want: carbon.example.com, 2003, nil", host, port, err)
+	}
+}
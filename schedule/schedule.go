@@ -0,0 +1,247 @@
+// Package schedule runs jobs, e.g the probes, on schedule. The runs of a job are either on a fixed grid, so that they
+// do not drift, the first runs being spread by a deterministic per-job jitter, or at the times of a cron expression.
+// The number of runs at once is limited, overall as well as per target.
+package schedule
+
+import (
+	"container/heap"
+	"github.com/samitpal/goProbe/cron"
+	"github.com/samitpal/goProbe/metric_export"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the scheduling limits.
+type Config struct {
+	MaxConcurrent int           // max number of runs at once. No limit if 0.
+	MaxPerTarget  int           // max number of runs at once against the same target. No limit if 0.
+	MaxJitter     time.Duration // the first run of a job is delayed by up to this, or up to its interval if shorter.
+}
+
+// Job is a job run on schedule.
+type Job struct {
+	Name     string
+	Target   string // e.g the host the job probes, for the per target limit. None if empty.
+	Interval time.Duration
+	Cron     *cron.Schedule // optional. The job is run at its times, in the local time zone, rather than every interval.
+
+	// Skip is optional. It tells whether the run due at the given time is to be skipped, e.g if the probe is paused.
+	Skip func(due time.Time) bool
+
+	// Run runs the job. It returns false if a stop signal is received in the meantime.
+	Run func() bool
+}
+
+// Scheduler runs the jobs on schedule within the limits of its config.
+type Scheduler struct {
+	c       Config
+	global  chan struct{} // the slots of the runs, nil if there is no limit.
+	lock    sync.Mutex
+	targets map[string]chan struct{} // the slots of the runs by target.
+
+	// accessed atomically.
+	lag     int64 // of the last run, in nano seconds.
+	running int64
+	missed  int64
+}
+
+// New returns a scheduler with the given limits.
+func New(c Config) *Scheduler {
+	s := &Scheduler{c: c, targets: make(map[string]chan struct{})}
+	if c.MaxConcurrent > 0 {
+		s.global = make(chan struct{}, c.MaxConcurrent)
+	}
+	return s
+}
+
+// RegisterMetrics adds the scheduling metrics to the exposed metrics.
+func (s *Scheduler) RegisterMetrics(mExp metric_export.MetricExporter) {
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "scheduling_lag_seconds", Help: "Delay between the time a probe run was due and its start, including the wait for the concurrency limits, for the last run.",
+		Value: func() float64 { return time.Duration(atomic.LoadInt64(&s.lag)).Seconds() }})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "probes_running", Help: "Number of scheduled probe runs in progress.",
+		Value: func() float64 { return float64(atomic.LoadInt64(&s.running)) }})
+	mExp.AddSelfMetric(metric_export.SelfMetric{Name: "scheduling_missed_runs_total", Help: "Number of probe runs not started because the previous run of the probe was still ongoing.", Counter: true,
+		Value: func() float64 { return float64(atomic.LoadInt64(&s.missed)) }})
+}
+
+// Jitter returns the delay of the first run of the job of the given name, from 0 to max excluded. It only depends on
+// the name, so that the runs of a job keep the same offset across restarts.
+func Jitter(name string, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// next returns the first run time strictly after the given time, the runs being at first, first+interval and so on.
+func next(first time.Time, interval time.Duration, after time.Time) time.Time {
+	if after.Before(first) {
+		return first
+	}
+	return first.Add((after.Sub(first)/interval + 1) * interval)
+}
+
+// due returns the first run of the job strictly after the given time. It is the zero time if there is none.
+func (j Job) due(first, after time.Time) time.Time {
+	if j.Cron != nil {
+		return j.Cron.Next(after)
+	}
+	return next(first, j.Interval, after)
+}
+
+// missed returns the number of runs of the job due between the given runs.
+func (j Job) missed(from, to time.Time) int64 {
+	if j.Cron == nil {
+		return int64(to.Sub(from)/j.Interval) - 1
+	}
+	var n int64
+	for t := j.Cron.Next(from); !t.IsZero() && t.Before(to); t = j.Cron.Next(t) {
+		n++
+	}
+	return n
+}
+
+// Acquire waits till a run against the given target is within the limits. It returns the function releasing the run,
+// or nil if a stop signal is received in the meantime.
+func (s *Scheduler) Acquire(target string, stopCh chan bool) func() {
+	// the target slot comes first, so that a run waiting for its target does not hold up the others.
+	var ts chan struct{}
+	if target != "" && s.c.MaxPerTarget > 0 {
+		s.lock.Lock()
+		if ts = s.targets[target]; ts == nil {
+			ts = make(chan struct{}, s.c.MaxPerTarget)
+			s.targets[target] = ts
+		}
+		s.lock.Unlock()
+		select {
+		case ts <- struct{}{}:
+		case <-stopCh:
+			return nil
+		}
+	}
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-stopCh:
+			if ts != nil {
+				<-ts
+			}
+			return nil
+		}
+	}
+	return func() {
+		if s.global != nil {
+			<-s.global
+		}
+		if ts != nil {
+			<-ts
+		}
+	}
+}
+
+// entry is a job in the run queue.
+type entry struct {
+	job   int       // index in the jobs.
+	first time.Time // the first run, which the next ones follow.
+	due   time.Time
+}
+
+// runQueue is a heap of the jobs waiting for their next run, by due time.
+type runQueue []*entry
+
+func (q runQueue) Len() int            { return len(q) }
+func (q runQueue) Less(i, j int) bool  { return q[i].due.Before(q[j].due) }
+func (q runQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *runQueue) Push(x interface{}) { *q = append(*q, x.(*entry)) }
+func (q *runQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Run runs the given jobs on schedule till a stop signal is received. A job is not run again before its run is over:
+// its next run is the first one due after the end of the run, the runs due in the meantime being missed. The cron
+// jobs are first run at their first time after the start, without jitter.
+func (s *Scheduler) Run(jobs []Job, stopCh chan bool) {
+	start := time.Now()
+	q := make(runQueue, 0, len(jobs))
+	for i, j := range jobs {
+		max := s.c.MaxJitter
+		if j.Interval < max {
+			max = j.Interval
+		}
+		first := start.Add(Jitter(j.Name, max))
+		if j.Cron != nil {
+			first = j.Cron.Next(start)
+		}
+		if first.IsZero() {
+			continue // a cron expression which never matches.
+		}
+		q = append(q, &entry{job: i, first: first, due: first})
+	}
+	heap.Init(&q)
+
+	doneCh := make(chan *entry)
+	for {
+		now := time.Now()
+		for q.Len() > 0 && !q[0].due.After(now) {
+			e := heap.Pop(&q).(*entry)
+			go s.runJob(jobs[e.job], e, doneCh, stopCh)
+		}
+		var timer *time.Timer
+		var dueCh <-chan time.Time
+		if q.Len() > 0 {
+			timer = time.NewTimer(q[0].due.Sub(now))
+			dueCh = timer.C
+		}
+		select {
+		case <-dueCh:
+		case e := <-doneCh:
+			j := jobs[e.job]
+			due := j.due(e.first, time.Now())
+			if due.IsZero() {
+				break
+			}
+			if n := j.missed(e.due, due); n > 0 {
+				atomic.AddInt64(&s.missed, n)
+			}
+			e.due = due
+			heap.Push(&q, e)
+		case <-stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// runJob runs the given job, unless skipped, and sends its queue entry back once done.
+func (s *Scheduler) runJob(j Job, e *entry, doneCh chan<- *entry, stopCh chan bool) {
+	if j.Skip == nil || !j.Skip(e.due) {
+		release := s.Acquire(j.Target, stopCh)
+		if release == nil {
+			return
+		}
+		atomic.StoreInt64(&s.lag, int64(time.Since(e.due)))
+		atomic.AddInt64(&s.running, 1)
+		ok := j.Run()
+		atomic.AddInt64(&s.running, -1)
+		release()
+		if !ok {
+			return
+		}
+	}
+	select {
+	case doneCh <- e:
+	case <-stopCh:
+	}
+}
@@ -0,0 +1,161 @@
+package schedule
+
+import (
+	"github.com/samitpal/goProbe/cron"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	max := 15 * time.Second
+	seen := make(map[time.Duration]bool)
+	for _, name := range []string{"web", "db_main", "db_replica", "ssh"} {
+		j := Jitter(name, max)
+		if j < 0 || j >= max {
+			t.Errorf("%s: Got: %v\n Want within [0, %v)", name, j, max)
+		}
+		if j != Jitter(name, max) {
+			t.Errorf("%s: Got a different jitter for the same name", name)
+		}
+		seen[j] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Got the same jitter for all the names")
+	}
+	if j := Jitter("web", 0); j != 0 {
+		t.Errorf("Got: %v\n Want: 0 for no jitter", j)
+	}
+}
+
+func TestNext(t *testing.T) {
+	first := time.Date(2016, 1, 29, 10, 0, 5, 0, time.UTC)
+	tests := []struct {
+		after time.Time
+		want  time.Time
+	}{
+		{first.Add(-time.Hour), first},
+		{first, first.Add(time.Minute)},
+		{first.Add(59 * time.Second), first.Add(time.Minute)},
+		{first.Add(time.Minute), first.Add(2 * time.Minute)},
+		{first.Add(150 * time.Second), first.Add(3 * time.Minute)},
+	}
+	for _, tt := range tests {
+		if got := next(first, time.Minute, tt.after); !got.Equal(tt.want) {
+			t.Errorf("After %v: Got: %v\n Want: %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	s := New(Config{MaxConcurrent: 2, MaxPerTarget: 1})
+	r1 := s.Acquire("host1", nil)
+
+	// host1 is at its limit, host2 takes the last slot.
+	stopCh := make(chan bool)
+	close(stopCh)
+	if r := s.Acquire("host1", stopCh); r != nil {
+		t.Errorf("Acquired a second slot for host1")
+	}
+	r2 := s.Acquire("host2", nil)
+	if r := s.Acquire("", stopCh); r != nil {
+		t.Errorf("Acquired a third slot")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		s.Acquire("host1", nil)()
+		acquired <- true
+	}()
+	r2()
+	select {
+	case <-acquired:
+		t.Errorf("Acquired a second slot for host1 once host2 released its slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	r1()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Errorf("Did not acquire a slot for host1 once released")
+	}
+
+	// no limits.
+	s = New(Config{})
+	for i := 0; i < 10; i++ {
+		if r := s.Acquire("host1", stopCh); r == nil {
+			t.Fatalf("Did not acquire a slot without limits")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := New(Config{MaxConcurrent: 1})
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	var running, overlaps int32
+	job := func(name string, d time.Duration) Job {
+		return Job{Name: name, Interval: 20 * time.Millisecond, Run: func() bool {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(d)
+			atomic.AddInt32(&running, -1)
+			mu.Lock()
+			runs[name]++
+			mu.Unlock()
+			return true
+		}}
+	}
+	skipped := Job{Name: "skipped", Interval: 20 * time.Millisecond, Skip: func(time.Time) bool { return true },
+		Run: func() bool {
+			t.Errorf("Ran a skipped job")
+			return true
+		}}
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		s.Run([]Job{job("fast", time.Millisecond), job("slow", 50*time.Millisecond), skipped}, stopCh)
+		doneCh <- true
+	}()
+	time.Sleep(300 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["fast"] < 3 || runs["slow"] < 2 {
+		t.Errorf("Got runs: %v", runs)
+	}
+	// the slow job overruns its interval.
+	if runs["slow"] > 6 || atomic.LoadInt64(&s.missed) == 0 {
+		t.Errorf("Got %d slow runs, %d missed", runs["slow"], atomic.LoadInt64(&s.missed))
+	}
+	if overlaps > 0 {
+		t.Errorf("Got %d runs over the concurrency limit", overlaps)
+	}
+}
+
+func TestDue(t *testing.T) {
+	c, err := cron.Parse("5 * * * *")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	j := Job{Name: "hourly", Cron: c}
+	first := time.Date(2016, 1, 29, 10, 5, 0, 0, time.UTC)
+	if got, want := j.due(first, first.Add(20*time.Second)), first.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+	// the run of 11:05 is missed by a run over an hour long.
+	if n := j.missed(first, first.Add(2*time.Hour)); n != 1 {
+		t.Errorf("Got %d missed runs\n Want: 1", n)
+	}
+	j = Job{Name: "fast", Interval: 500 * time.Millisecond}
+	if got, want := j.due(first, first.Add(1200*time.Millisecond)), first.Add(1500*time.Millisecond); !got.Equal(want) {
+		t.Errorf("Got: %v\n Want: %v", got, want)
+	}
+	if n := j.missed(first, first.Add(1500*time.Millisecond)); n != 2 {
+		t.Errorf("Got %d missed runs\n Want: 2", n)
+	}
+}
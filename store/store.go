@@ -0,0 +1,181 @@
+// Package store saves the probe metrics, status, history and runtime control to a file so that they survive a restart
+// of goProbe.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/misc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// FileName is the name of the state file within the state directory.
+	FileName = "goprobe_state.json"
+
+	formatVersion = 1 // the version of the state file format.
+)
+
+// savedProbe is the saved state of a probe.
+type savedProbe struct {
+	Metrics []metric_export.Sample `json:"metrics,omitempty"`
+	Status  *misc.SavedStatus      `json:"status,omitempty"`
+	Control *misc.ProbeControl     `json:"control,omitempty"`
+}
+
+// snapshot is the content of the state file.
+type snapshot struct {
+	Version int                   `json:"version"`
+	Time    int64                 `json:"time"` // Unix epoch in seconds.
+	Probes  map[string]savedProbe `json:"probes"`
+}
+
+// Store periodically saves the metrics, status, history and runtime control of the probes to a file in a given
+// directory.
+type Store struct {
+	path     string
+	interval time.Duration
+	mExp     metric_export.MetricExporter
+	ps       *misc.ProbesStatus
+	ctl      misc.Controller // may be nil.
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewStore returns a store saving to the state file in the given directory every interval. The directory is created
+// if needed. The runtime control of the probes, i.e whether they are paused or silenced, is saved too if ctl is set.
+func NewStore(dir string, interval time.Duration, mExp metric_export.MetricExporter, ps *misc.ProbesStatus, ctl misc.Controller) (*Store, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid state save interval %v", interval)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		path:     filepath.Join(dir, FileName),
+		interval: interval,
+		mExp:     mExp,
+		ps:       ps,
+		ctl:      ctl,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// Restore restores the saved metrics, status, history and runtime control of the current probes. The probes no longer in the config
+// are left out. It does nothing if there is no state file yet. A state file which can not be read is moved aside with
+// a .corrupt suffix and an error is returned, nothing being restored, so that goProbe can start with an empty state.
+// It should be called before any probe run.
+func (s *Store) Restore() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err == nil && snap.Version != formatVersion {
+		err = fmt.Errorf("unsupported version %d", snap.Version)
+	}
+	if err != nil {
+		if rerr := os.Rename(s.path, s.path+".corrupt"); rerr != nil {
+			glog.Errorf("Failed to move aside the state file %s: %v", s.path, rerr)
+		}
+		return fmt.Errorf("Invalid state file %s: %v", s.path, err)
+	}
+
+	for _, pn := range s.ps.Probes {
+		p, ok := snap.Probes[pn]
+		if !ok {
+			continue
+		}
+		s.mExp.Restore(pn, p.Metrics)
+		if p.Status != nil {
+			s.ps.Restore(pn, p.Status)
+		}
+		if p.Control != nil && s.ctl != nil {
+			if p.Control.Paused {
+				s.ctl.Pause(pn, true)
+			}
+			if p.Control.SilencedUntil != nil && p.Control.SilencedUntil.After(time.Now()) {
+				s.ctl.Silence(pn, *p.Control.SilencedUntil)
+			}
+		}
+	}
+	glog.Infof("Restored the probe state saved at %v from %s", time.Unix(snap.Time, 0), s.path)
+	return nil
+}
+
+// Save saves the metrics, status, history and runtime control of the probes. The state file is replaced at once so that a crash while
+// saving leaves the previous one.
+func (s *Store) Save() error {
+	snap := snapshot{Version: formatVersion, Time: time.Now().Unix(), Probes: make(map[string]savedProbe)}
+	for _, pn := range s.ps.Probes {
+		p := savedProbe{Metrics: s.mExp.Snapshot(pn), Status: s.ps.Save(pn)}
+		if s.ctl != nil {
+			if c := s.ctl.Control(pn); c.Paused || c.SilencedUntil != nil {
+				c.Maintenance = "" // derived from the config.
+				p.Control = &c
+			}
+		}
+		if len(p.Metrics) == 0 && p.Status == nil && p.Control == nil {
+			continue
+		}
+		snap.Probes[pn] = p
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), FileName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed.
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Start starts saving every interval.
+func (s *Store) Start() {
+	go s.loop()
+}
+
+// Stop stops the periodic saves and saves a last time.
+func (s *Store) Stop() error {
+	close(s.stopCh)
+	<-s.doneCh
+	return s.Save()
+}
+
+func (s *Store) loop() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				glog.Errorf("Failed to save the probe state to %s: %v", s.path, err)
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
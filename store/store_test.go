@@ -0,0 +1,154 @@
+package store
+
+import (
+	"github.com/samitpal/goProbe/metric_export"
+	"github.com/samitpal/goProbe/misc"
+	"github.com/samitpal/goProbe/modules"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goprobe_store")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mExp := metric_export.NewJSONExport()
+	ps := misc.NewProbesStatus([]string{"probe1", "probe2"})
+	up, latency, payload := float64(1), float64(12), []byte("body")
+	st := int64(1450000000000000000)
+	mExp.IncProbeCount("probe1", st/1e9)
+	mExp.IncProbeResultCount("probe1", metric_export.ResultSuccess, st/1e9)
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &up, Latency: &latency, Payload: &payload}, st, st)
+	ps.AddHistory("probe1", misc.HistoryEntry{StartTime: st, Result: metric_export.ResultSuccess, Latency: &latency})
+
+	s, err := NewStore(dir, time.Minute, mExp, ps, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// restart, with probe1 still in the config.
+	mExp = metric_export.NewJSONExport()
+	ps = misc.NewProbesStatus([]string{"probe1", "probe3"})
+	s, err = NewStore(dir, time.Minute, mExp, ps, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = s.Restore(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got := mExp.Snapshot("probe1"); len(got) != 2 {
+		t.Errorf("Got samples: %v\n Want the count and the success result count", got)
+	}
+	status := ps.ReadProbeStatus("probe1")
+	if status == nil || status.State != misc.StateUp || *status.ProbeResp.Latency != latency || status.ProbeResp.Payload != nil {
+		t.Errorf("Got status: %+v", status)
+	}
+	if h := ps.ReadHistory("probe1"); len(h) != 1 || h[0].StartTime != st {
+		t.Errorf("Got history: %+v", h)
+	}
+
+	// the restored state carries on.
+	down := float64(0)
+	ps.WriteProbeStatus("probe1", &modules.ProbeData{IsUp: &down, Latency: &latency}, st+1, st+1)
+	if got := ps.ReadProbeStatus("probe1"); got.State != misc.StateDown || got.StateSince != st+1 {
+		t.Errorf("Got: %s since %d\n Want: down since %d", got.State, got.StateSince, st+1)
+	}
+}
+
+// controls is a misc.Controller of the controls of the probes.
+type controls map[string]misc.ProbeControl
+
+func (c controls) Control(pn string) misc.ProbeControl {
+	return c[pn]
+}
+
+func (c controls) Pause(pn string, paused bool) {
+	pc := c[pn]
+	pc.Paused = paused
+	c[pn] = pc
+}
+
+func (c controls) Silence(pn string, until time.Time) {
+	pc := c[pn]
+	pc.SilencedUntil = &until
+	c[pn] = pc
+}
+
+func TestSaveRestoreControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goprobe_store")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	expired := time.Now().Add(-time.Hour)
+	ctl := controls{"probe1": {Paused: true}, "probe2": {SilencedUntil: &until}, "probe3": {SilencedUntil: &expired}}
+	names := []string{"probe1", "probe2", "probe3"}
+	s, err := NewStore(dir, time.Minute, metric_export.NewJSONExport(), misc.NewProbesStatus(names), ctl)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	ctl = make(controls)
+	s, err = NewStore(dir, time.Minute, metric_export.NewJSONExport(), misc.NewProbesStatus(names), ctl)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = s.Restore(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(ctl) != 2 || !ctl["probe1"].Paused || ctl["probe1"].SilencedUntil != nil || !ctl["probe2"].SilencedUntil.Equal(until) {
+		t.Errorf("Got: %+v", ctl)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goprobe_store")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mExp := metric_export.NewJSONExport()
+	ps := misc.NewProbesStatus([]string{"probe1"})
+	s, err := NewStore(dir, time.Minute, mExp, ps, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	// no state file yet.
+	if err = s.Restore(); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	for _, c := range []string{`{"version": 1, "probes": {"probe1": `, `{"version": 99, "probes": {}}`} {
+		path := filepath.Join(dir, FileName)
+		if err = ioutil.WriteFile(path, []byte(c), 0644); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err = s.Restore(); err == nil {
+			t.Errorf("Expecting error for state file %s, but test is passing", c)
+		}
+		if _, err = os.Stat(path + ".corrupt"); err != nil {
+			t.Errorf("Expecting the state file to be moved aside: %v", err)
+		}
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expecting no state file, got: %v", err)
+		}
+	}
+	if got := mExp.Snapshot("probe1"); len(got) != 0 {
+		t.Errorf("Got samples: %v\n Want none", got)
+	}
+}
@@ -0,0 +1,9 @@
+// Package templates embeds the html templates of the goProbe web pages.
+package templates
+
+import "embed"
+
+// FS holds the html templates.
+//
+//go:embed *.html
+var FS embed.FS
@@ -0,0 +1,15 @@
+// Package version holds the build information of goProbe. The values are meant to be set at build time, e.g
+// go build -ldflags "-X github.com/samitpal/goProbe/version.Version=1.0.0 -X github.com/samitpal/goProbe/version.Revision=$(git rev-parse HEAD)"
+package version
+
+import (
+	"runtime"
+)
+
+var (
+	Version   = "unknown"
+	Revision  = "unknown"
+	Branch    = "unknown"
+	BuildDate = "unknown"
+	GoVersion = runtime.Version()
+)